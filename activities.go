@@ -3,22 +3,19 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"image"
-	"image/jpeg"
-	"image/png"
 	"log/slog"
 	"os/exec"
 	"strings"
 	"time"
 
 	"github.com/brojonat/forohtoo/client"
-	"github.com/chai2010/webp"
-	"github.com/nfnt/resize"
 	"go.temporal.io/sdk/activity"
 	"go.temporal.io/sdk/temporal"
-	"google.golang.org/genai"
 )
 
 const (
@@ -26,14 +23,10 @@ const (
 	USDCMintAddress = "EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v"
 )
 
-// GenerateResponsesTurnInput holds the parameters for the GenerateResponsesTurnActivity.
-type GenerateResponsesTurnInput struct {
-	OpenAIConfig       OpenAIConfig
-	PreviousResponseID string
-	UserInput          string
-	Tools              []Tool
-	FunctionOutputs    map[string]string
-	ToolChoice         any
+// GenerateLLMTurnInput holds the parameters for the GenerateLLMTurnActivity.
+type GenerateLLMTurnInput struct {
+	OpenAIConfig OpenAIConfig
+	Request      LLMRequest
 }
 
 // ExecuteGhCommandActivity is an activity that executes a GitHub CLI command.
@@ -82,16 +75,31 @@ func truncateString(s string, maxLen int) string {
 	return s[:maxLen] + "..."
 }
 
-// GenerateResponsesTurnActivity is an activity that generates a turn in the agentic conversation.
-func GenerateResponsesTurnActivity(ctx context.Context, input GenerateResponsesTurnInput) (GenerateResponsesTurnResult, error) {
-	text, calls, id, err := generateResponsesTurn(ctx, input.OpenAIConfig, input.PreviousResponseID, input.UserInput, input.Tools, input.FunctionOutputs, input.ToolChoice)
+// GenerateLLMTurnActivity is an activity that generates a turn in the agentic conversation
+// with the configured LLM backend.
+func GenerateLLMTurnActivity(ctx context.Context, input GenerateLLMTurnInput) (LLMResponse, error) {
+	client, err := NewLLMClient(input.OpenAIConfig)
 	if err != nil {
-		return GenerateResponsesTurnResult{}, err
+		return LLMResponse{}, temporal.NewNonRetryableApplicationError(err.Error(), "UnknownLLMProvider", nil)
 	}
-	return GenerateResponsesTurnResult{Assistant: text, Calls: calls, ID: id}, nil
+	resp, err := client.Generate(ctx, input.Request)
+	if err != nil {
+		return LLMResponse{}, err
+	}
+	resp.Usage = priceUsage(appConfig, input.OpenAIConfig.Model, resp.Usage)
+	return resp, nil
 }
 
+// executeGhCommand runs gh with command's arguments, or replays a recorded run when
+// FIXTURE_MODE is set.
 func executeGhCommand(ctx context.Context, command string) (string, error) {
+	if appFixtures != nil {
+		return appFixtures.ghCommand(ctx, command, runGhCommand)
+	}
+	return runGhCommand(ctx, command)
+}
+
+func runGhCommand(ctx context.Context, command string) (string, error) {
 	// Use sh -c to properly handle quoted strings in the command
 	fullCommand := "gh " + command
 	cmd := exec.CommandContext(ctx, "sh", "-c", fullCommand)
@@ -106,7 +114,10 @@ func executeGhCommand(ctx context.Context, command string) (string, error) {
 	return out.String(), nil
 }
 
+// OpenAIConfig configures an LLM backend. Despite the name it covers every provider in
+// llmProviders; Provider picks one and defaults to the OpenAI Responses API.
 type OpenAIConfig struct {
+	Provider  string
 	APIKey    string
 	Model     string
 	MaxTokens int
@@ -122,8 +133,9 @@ type CopyObjectInput struct {
 	DestinationKey    string
 }
 
-// GeneratePrompt creates a "report card" prompt for content generation based on GitHub profile
-func GenerateContentGenerationPrompt(ctx context.Context, profile GitHubProfile, systemPrompt string) (string, error) {
+// GeneratePrompt creates a "report card" prompt for content generation based on GitHub profile.
+// withAvatar adds instructions for using the developer's avatar as a reference image.
+func GenerateContentGenerationPrompt(ctx context.Context, profile GitHubProfile, systemPrompt string, withAvatar bool) (string, error) {
 	// Build a comprehensive "report card" prompt that grounds the profile in cultural context
 	prompt := fmt.Sprintf(`%s
 **Developer Report Card:**
@@ -168,113 +180,227 @@ Based on their profile, create a visual that puts them in modern cultural contex
 		profile.ContributionGraph.TotalContributions,
 		profile.ContributionGraph.Streak,
 	)
+	if withAvatar {
+		prompt += avatarPromptBlock
+	}
 
 	return prompt, nil
 }
 
-// GenerateContentOutput holds the return values for the GenerateContent activity
-type GenerateContentOutput struct {
-	ImageData   []byte `json:"image_data"`
-	ContentType string `json:"content_type"`
+// GenerateContentInput defines the input for the GenerateContent activity.
+type GenerateContentInput struct {
+	Prompt        string
+	ModelName     string
+	ImageFormat   string // e.g., "jpeg", "webp", "png"
+	ImageWidth    int
+	ImageHeight   int
+	StorageBucket string
+	StorageKey    string // optional: if empty, a key is generated under KeyPrefix
+	KeyPrefix     string
+	// GitHubUsername is recorded in the stored object's metadata for provenance.
+	GitHubUsername string
+	// Watermark stamps the images with the site name and a link to PollID (or the user's
+	// profile) and embeds provenance metadata marking them as AI-generated.
+	Watermark bool
+	PollID    string
+	// Candidates is how many images to generate and choose from with Scorer (a name in
+	// candidateScorers). MaxAttempts bounds the model calls; below Candidates it means
+	// Candidates+2.
+	Candidates  int
+	MaxAttempts int
+	Scorer      string
+	// ReferenceImageKey optionally names a stored image (see FetchGitHubAvatar) the model
+	// should condition on. The prompt should have been built with the avatar block.
+	ReferenceImageKey string
+	// Variation starts the attempt numbering later so even the first model call appends a
+	// candidateVariations entry to Prompt; near-duplicate regeneration uses it.
+	Variation int
 }
 
-// GenerateContent uses a frontier model to generate content and optionally convert it
-func GenerateContent(ctx context.Context, prompt, modelName, imageFormat string, imageWidth, imageHeight int) (GenerationResult, error) {
-	apiKey := appConfig.GoogleAPIKey
-	if apiKey == "" {
-		return GenerationResult{}, fmt.Errorf("GOOGLE_API_KEY not configured")
+// GenerateContent uses a frontier model to generate one or more candidate images, keeps
+// the best-scoring one, optionally converts it, and uploads it straight to object
+// storage. Only the storage key and URL are returned so image bytes never pass through
+// workflow history. Unless StorageKey is set, every variant is stored once under its
+// content hash and a reference record is added to KeyPrefix.
+func GenerateContent(ctx context.Context, input GenerateContentInput) (GenerationResult, error) {
+	// Fail before paying for a generation that can't be encoded
+	if err := checkImageFormat(input.ImageFormat); err != nil {
+		return GenerationResult{}, temporal.NewNonRetryableApplicationError(err.Error(), "UnsupportedImageFormat", nil)
 	}
 
-	// Initialize Gemini client. It will use the GOOGLE_API_KEY environment variable if it is set.
-	client, err := genai.NewClient(ctx, nil)
+	want := max(input.Candidates, 1)
+	maxAttempts := input.MaxAttempts
+	if maxAttempts < want {
+		maxAttempts = want + 2
+	}
+	// Scoring a lone candidate would only cost a model call
+	var scorer CandidateScorer
+	if want > 1 {
+		var err error
+		if scorer, err = newCandidateScorer(input.Scorer, appConfig); err != nil {
+			return GenerationResult{}, temporal.NewNonRetryableApplicationError(err.Error(), "UnknownImageScorer", nil)
+		}
+	}
+	req := ImageRequest{Prompt: input.Prompt, Width: input.ImageWidth, Height: input.ImageHeight}
+	if input.ReferenceImageKey != "" {
+		ref, err := readReferenceImage(ctx, input.StorageBucket, input.ReferenceImageKey)
+		if err != nil {
+			return GenerationResult{}, err
+		}
+		req.Reference = ref
+	}
+	usageCtx, usage := withUsageRecorder(ctx)
+	candidates, err := generateCandidates(usageCtx, input.ModelName, req, input.Variation, want, maxAttempts, scorer)
 	if err != nil {
-		return GenerationResult{}, fmt.Errorf("failed to create genai client: %w", err)
+		return GenerationResult{}, err
 	}
+	originalData := candidates[0].Data
 
-	// Generate the image
-	result, err := client.Models.GenerateContent(
-		ctx,
-		modelName,
-		genai.Text(prompt),
-		nil,
-	)
+	// Hash the model output rather than a variant so watermarks don't make images look alike
+	phash, err := perceptualHash(originalData)
 	if err != nil {
-		return GenerationResult{}, fmt.Errorf("failed to generate content: %w", err)
+		return GenerationResult{}, err
 	}
 
-	if len(result.Candidates) == 0 || result.Candidates[0].Content == nil || len(result.Candidates[0].Content.Parts) == 0 {
-		return GenerationResult{}, fmt.Errorf("no content returned from API")
+	info := activity.GetInfo(ctx)
+	var stamp *Stamp
+	if input.Watermark {
+		stamp = newStamp(input.PollID, input.GitHubUsername, input.ModelName, info.WorkflowExecution.ID)
 	}
 
-	var originalImageData []byte
-	for _, part := range result.Candidates[0].Content.Parts {
-		if part.InlineData != nil {
-			originalImageData = part.InlineData.Data
-			break
-		}
+	variants, err := renderVariants(originalData, input.ImageFormat, input.ImageWidth, input.ImageHeight, stamp)
+	if err != nil {
+		return GenerationResult{}, err
 	}
 
-	if originalImageData == nil {
-		return GenerationResult{}, fmt.Errorf("no image data returned")
-	}
+	metadata := contentMetadata(input.GitHubUsername, info.WorkflowExecution.ID, info.WorkflowExecution.RunID, input.ModelName, input.Prompt)
 
-	// If no format or dimensions are specified, return the original image
-	if imageFormat == "" && imageWidth == 0 && imageHeight == 0 {
-		return GenerationResult{
-			ImageData:   originalImageData,
-			ContentType: "image/png", // Assuming default is png
-		}, nil
+	// Keep every candidate so an admin can swap in a runner-up later
+	var stored []ImageCandidate
+	if len(candidates) > 1 {
+		for _, c := range candidates {
+			key, url, err := putBlob(ctx, appStorage, input.StorageBucket, c.Data, c.ContentType, metadata)
+			if err != nil {
+				return GenerationResult{}, fmt.Errorf("failed to store candidate %d: %w", c.Attempt, err)
+			}
+			c.Key, c.URL = key, url
+			stored = append(stored, c.ImageCandidate)
+		}
 	}
-
-	img, _, err := image.Decode(bytes.NewReader(originalImageData))
-	if err != nil {
-		return GenerationResult{}, fmt.Errorf("failed to decode image: %w", err)
+	metadata[MetaPerceptualHash] = phash
+
+	// An explicit key asks for the image at that exact location, so store only the
+	// full variant there and skip deduplication
+	if input.StorageKey != "" {
+		for _, v := range variants {
+			if v.Name != VariantFull {
+				continue
+			}
+			publicURL, err := appStorage.Put(ctx, input.StorageBucket, input.StorageKey, bytes.NewReader(v.Data), int64(len(v.Data)), v.ContentType, metadata)
+			if err != nil {
+				return GenerationResult{}, fmt.Errorf("failed to store generated content: %w", err)
+			}
+			return GenerationResult{
+				Prompt:         input.Prompt,
+				PublicURL:      publicURL,
+				StorageKey:     input.StorageKey,
+				ContentType:    v.ContentType,
+				PerceptualHash: phash,
+				Candidates:     stored,
+				Usage:          usage.Usage(),
+			}, nil
+		}
 	}
 
-	// Resize the image if dimensions are provided
-	if imageWidth > 0 || imageHeight > 0 {
-		img = resize.Resize(uint(imageWidth), uint(imageHeight), img, resize.Lanczos3)
+	variantsByName := make(map[string]ImageVariant, len(variants))
+	for _, v := range variants {
+		key, url, err := putBlob(ctx, appStorage, input.StorageBucket, v.Data, v.ContentType, metadata)
+		if err != nil {
+			return GenerationResult{}, fmt.Errorf("failed to store %s variant: %w", v.Name, err)
+		}
+		stored := ImageVariant{Key: key, URL: url, ContentType: v.ContentType, Width: v.Width, Height: v.Height}
+		for altType, altData := range v.Alternates {
+			altKey, _, err := putBlob(ctx, appStorage, input.StorageBucket, altData, altType, metadata)
+			if err != nil {
+				return GenerationResult{}, fmt.Errorf("failed to store %s variant as %s: %w", v.Name, altType, err)
+			}
+			if stored.Alternates == nil {
+				stored.Alternates = make(map[string]string)
+			}
+			stored.Alternates[altType] = altKey
+		}
+		variantsByName[v.Name] = stored
+		activity.RecordHeartbeat(ctx, v.Name)
 	}
 
-	var buf bytes.Buffer
-	var contentType string
-
-	switch strings.ToLower(imageFormat) {
-	case "jpeg", "jpg":
-		contentType = "image/jpeg"
-		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80})
-	case "webp":
-		contentType = "image/webp"
-		err = webp.Encode(&buf, img, &webp.Options{Quality: 75})
-	case "png":
-		contentType = "image/png"
-		err = png.Encode(&buf, img)
-	default:
-		// If an unsupported format is specified, return the original image
-		return GenerationResult{
-			ImageData:   originalImageData,
-			ContentType: "image/png",
-		}, nil
+	full := variantsByName[VariantFull]
+	ref := ImageRef{
+		Blob:           full.Key,
+		ContentType:    full.ContentType,
+		Username:       input.GitHubUsername,
+		CreatedAt:      time.Now().UTC(),
+		Variants:       variantKeys(variantsByName),
+		PerceptualHash: phash,
+		Candidates:     candidateKeys(stored),
 	}
-
-	if err != nil {
-		return GenerationResult{}, fmt.Errorf("failed to encode image to %s: %w", imageFormat, err)
+	refKey := userRefKey(input.KeyPrefix)
+	if err := writeImageRef(ctx, appStorage, input.StorageBucket, refKey, ref, metadata); err != nil {
+		return GenerationResult{}, err
 	}
 
 	return GenerationResult{
-		ImageData:   buf.Bytes(),
-		ContentType: contentType,
+		Prompt:         input.Prompt,
+		PublicURL:      full.URL,
+		StorageKey:     full.Key,
+		ContentType:    full.ContentType,
+		Variants:       variantsByName,
+		PerceptualHash: phash,
+		Candidates:     stored,
+		RefKey:         refKey,
+		Usage:          usage.Usage(),
 	}, nil
 }
 
+// contentMetadata builds the provenance metadata stored alongside generated content.
+func contentMetadata(username, workflowID, runID, modelName, prompt string) map[string]string {
+	promptHash := sha256.Sum256([]byte(prompt))
+	return map[string]string{
+		MetaGitHubUsername: username,
+		MetaWorkflowID:     workflowID,
+		MetaRunID:          runID,
+		MetaModelName:      modelName,
+		MetaPromptSHA256:   hex.EncodeToString(promptHash[:]),
+		MetaCreatedAt:      time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// generateImage calls the image model selected by modelName's prefix (see NewImageGenerator)
+// and returns the image exactly as the model produced it.
+func generateImage(ctx context.Context, modelName string, req ImageRequest) ([]byte, error) {
+	generator, model := NewImageGenerator(appConfig, modelName)
+	req.Model = model
+	data, err := generator.Generate(ctx, req)
+
+	// Generators that report tokens record them themselves; calls and images are counted
+	// here for every generator, under the name the provider knows the model by
+	usageModel := model
+	if usageModel == "" {
+		usageModel = modelName
+	}
+	usage := TokenUsage{Calls: 1}
+	if err == nil {
+		usage.Images = 1
+	}
+	recordUsage(ctx, usageModel, usage)
+	return data, err
+}
+
 // CopyObject copies an object from one location to another in the object storage.
 func CopyObject(ctx context.Context, input CopyObjectInput) error {
 	logger := activity.GetLogger(ctx)
 	logger.Info("Copying object", "from", input.SourceKey, "to", input.DestinationKey)
 
-	storage := NewObjectStorage(appConfig)
-
-	err := storage.Copy(ctx, input.SourceBucket, input.SourceKey, input.DestinationBucket, input.DestinationKey)
+	err := appStorage.Copy(ctx, input.SourceBucket, input.SourceKey, input.DestinationBucket, input.DestinationKey)
 	if err != nil {
 		logger.Error("Failed to copy object", "error", err)
 		return fmt.Errorf("failed to copy object: %w", err)
@@ -284,6 +410,133 @@ func CopyObject(ctx context.Context, input CopyObjectInput) error {
 	return nil
 }
 
+// LinkPollImageInput defines the input for the LinkPollImage activity.
+type LinkPollImageInput struct {
+	Bucket         string
+	PollID         string
+	Username       string
+	BlobKey        string
+	ContentType    string
+	Variants       map[string]ImageVariant
+	PerceptualHash string
+}
+
+// LinkPollImage points a poll option at an existing blob by writing a reference
+// record, so the image isn't copied into every poll that uses it.
+func LinkPollImage(ctx context.Context, input LinkPollImageInput) error {
+	logger := activity.GetLogger(ctx)
+	key := pollRefKey(input.PollID, input.Username)
+
+	ref := ImageRef{
+		Blob:           input.BlobKey,
+		ContentType:    input.ContentType,
+		Username:       input.Username,
+		CreatedAt:      time.Now().UTC(),
+		Variants:       variantKeys(input.Variants),
+		PerceptualHash: input.PerceptualHash,
+	}
+	if err := writeImageRef(ctx, appStorage, input.Bucket, key, ref, nil); err != nil {
+		logger.Error("Failed to link poll image", "key", key, "error", err)
+		return err
+	}
+
+	logger.Info("Linked poll image", "key", key, "blob", input.BlobKey)
+	return nil
+}
+
+// pollArchiveFolder is the top-level folder torn-down polls are archived under.
+const pollArchiveFolder = "archive"
+
+// PollManifest is the final results record written when a poll is torn down.
+type PollManifest struct {
+	PollID    string         `json:"poll_id"`
+	Question  string         `json:"question"`
+	Options   map[string]int `json:"options"`
+	ImageKeys []string       `json:"image_keys"`
+	ClosedAt  time.Time      `json:"closed_at"`
+}
+
+// ArchivePollManifestInput defines the input for the ArchivePollManifest activity.
+type ArchivePollManifestInput struct {
+	Bucket   string
+	Manifest PollManifest
+}
+
+// ArchivePollManifest records the poll's image (blob) keys in the manifest and stores it
+// at archive/<pollID>/manifest.json. It returns the manifest's key.
+func ArchivePollManifest(ctx context.Context, input ArchivePollManifestInput) (string, error) {
+	logger := activity.GetLogger(ctx)
+	manifest := input.Manifest
+
+	keys, err := appStorage.List(ctx, input.Bucket, manifest.PollID+"/")
+	if err != nil {
+		return "", fmt.Errorf("failed to list poll images: %w", err)
+	}
+	// Record the blobs the poll's references point at rather than the references themselves
+	for _, key := range keys {
+		if strings.HasSuffix(key, refSuffix) {
+			ref, err := readImageRef(ctx, appStorage, input.Bucket, key)
+			if err != nil {
+				return "", err
+			}
+			key = ref.Blob
+		}
+		manifest.ImageKeys = append(manifest.ImageKeys, key)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal poll manifest: %w", err)
+	}
+
+	key := pollArchiveFolder + "/" + manifest.PollID + "/manifest.json"
+	if _, err := appStorage.Put(ctx, input.Bucket, key, bytes.NewReader(data), int64(len(data)), "application/json", nil); err != nil {
+		return "", fmt.Errorf("failed to store poll manifest: %w", err)
+	}
+	logger.Info("Stored poll manifest", "key", key, "images", len(keys))
+	return key, nil
+}
+
+// TeardownPollFolderInput defines the input for the TeardownPollFolder activity.
+type TeardownPollFolderInput struct {
+	Bucket string
+	PollID string
+	Mode   string // PollTeardownArchive or PollTeardownDelete
+}
+
+// TeardownPollFolder removes a closed poll's folder, first moving its objects under
+// archive/<pollID>/ when Mode is PollTeardownArchive.
+func TeardownPollFolder(ctx context.Context, input TeardownPollFolderInput) error {
+	logger := activity.GetLogger(ctx)
+	prefix := input.PollID + "/"
+
+	switch input.Mode {
+	case PollTeardownArchive:
+		keys, err := appStorage.List(ctx, input.Bucket, prefix)
+		if err != nil {
+			return fmt.Errorf("failed to list poll folder: %w", err)
+		}
+		for _, key := range keys {
+			dest := pollArchiveFolder + "/" + key
+			if err := appStorage.Copy(ctx, input.Bucket, key, input.Bucket, dest); err != nil {
+				return fmt.Errorf("failed to archive %s: %w", key, err)
+			}
+			activity.RecordHeartbeat(ctx, key)
+		}
+		logger.Info("Archived poll folder", "prefix", prefix, "objects", len(keys))
+	case PollTeardownDelete:
+	default:
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("unknown poll teardown mode %q", input.Mode), "InvalidTeardownMode", nil)
+	}
+
+	if err := appStorage.Delete(ctx, input.Bucket, prefix); err != nil {
+		return fmt.Errorf("failed to delete poll folder: %w", err)
+	}
+	logger.Info("Deleted poll folder", "prefix", prefix)
+	return nil
+}
+
 // StoreContentOutput is the output from the StoreContent activity.
 type StoreContentOutput struct {
 	PublicURL   string
@@ -304,11 +557,8 @@ func StoreContent(ctx context.Context, data []byte, provider, bucket, key, keyPr
 		key = generateStorageKey(keyPrefix, contentType)
 	}
 
-	// Create storage instance
-	storage := NewObjectStorage(appConfig)
-
 	// Store the content
-	publicURL, err := storage.Store(ctx, data, bucket, key, contentType)
+	publicURL, err := appStorage.Store(ctx, data, bucket, key, contentType)
 	if err != nil {
 		return StoreContentOutput{}, err
 	}
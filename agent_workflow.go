@@ -10,7 +10,8 @@ import (
 )
 
 // AgenticScrapeGitHubProfileWorkflow is a workflow that uses an agentic approach to scrape GitHub profile data.
-func AgenticScrapeGitHubProfileWorkflow(ctx workflow.Context, prompt string) (GitHubProfile, error) {
+// Once the agent has spent budget, its next turn may only submit the profile gathered so far.
+func AgenticScrapeGitHubProfileWorkflow(ctx workflow.Context, prompt string, budget LLMBudget) (ProfileScrapeResult, error) {
 	logger := workflow.GetLogger(ctx)
 	logger.Info("Starting agentic GitHub profile scrape workflow")
 
@@ -24,31 +25,24 @@ func AgenticScrapeGitHubProfileWorkflow(ctx workflow.Context, prompt string) (Gi
 		return conversation, nil
 	})
 	if err != nil {
-		return GitHubProfile{}, fmt.Errorf("failed to set query handler: %w", err)
+		return ProfileScrapeResult{}, fmt.Errorf("failed to set query handler: %w", err)
+	}
+	var usage Usage
+	err = workflow.SetQueryHandler(ctx, "getUsage", func() (Usage, error) {
+		return usage, nil
+	})
+	if err != nil {
+		return ProfileScrapeResult{}, fmt.Errorf("failed to set query handler: %w", err)
 	}
 
-	submitTool := Tool{
-		Name:        "submit_github_profile",
-		Description: "REQUIRED: Submit the final GitHub profile information. You MUST call this function once you have gathered the basic profile data (username, bio, location, repos, languages, top repos, contribution graph, and professional summary). Do NOT ask for permission or wait for further instructions - call this immediately when you have the required data.",
-		Parameters: map[string]interface{}{
-			"type": "object",
-			"properties": map[string]interface{}{
-				"username":             map[string]string{"type": "string"},
-				"bio":                  map[string]string{"type": "string"},
-				"location":             map[string]string{"type": "string"},
-				"website":              map[string]string{"type": "string"},
-				"public_repos":         map[string]string{"type": "integer"},
-				"original_repos":       map[string]string{"type": "integer"},
-				"forked_repos":         map[string]string{"type": "integer"},
-				"languages":            map[string]interface{}{"type": "array", "items": map[string]string{"type": "string"}},
-				"top_repositories":     map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "object", "properties": map[string]interface{}{"name": map[string]string{"type": "string"}, "description": map[string]string{"type": "string"}, "language": map[string]string{"type": "string"}, "stars": map[string]string{"type": "integer"}, "forks": map[string]string{"type": "integer"}, "is_fork": map[string]string{"type": "boolean"}}, "required": []string{"name", "description", "language", "stars", "forks", "is_fork"}, "additionalProperties": false}},
-				"contribution_graph":   map[string]interface{}{"type": "object", "properties": map[string]interface{}{"total_contributions": map[string]string{"type": "integer"}, "streak": map[string]string{"type": "integer"}, "contributions": map[string]interface{}{"type": "object", "additionalProperties": map[string]string{"type": "integer"}}}, "required": []string{"total_contributions", "streak"}, "additionalProperties": false},
-				"professional_summary": map[string]string{"type": "string"},
-				"code_snippets":        map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "object", "properties": map[string]interface{}{"repository": map[string]string{"type": "string"}, "file_path": map[string]string{"type": "string"}, "content": map[string]string{"type": "string"}, "language": map[string]string{"type": "string"}}, "required": []string{"repository", "file_path", "content", "language"}, "additionalProperties": false}},
-			},
-			"required":             []string{"username", "bio", "location", "website", "public_repos", "original_repos", "forked_repos", "languages", "top_repositories", "contribution_graph", "professional_summary", "code_snippets"},
-			"additionalProperties": false,
-		},
+	// The schema comes from GitHubProfile itself, so the tool can't drift from the type
+	submitTool, err := toolFromStruct(
+		"submit_github_profile",
+		"REQUIRED: Submit the final GitHub profile information. You MUST call this function once you have gathered the basic profile data (username, bio, location, repos, languages, top repos, contribution graph, and professional summary). Do NOT ask for permission or wait for further instructions - call this immediately when you have the required data.",
+		GitHubProfile{},
+	)
+	if err != nil {
+		return ProfileScrapeResult{}, err
 	}
 
 	ghTool := Tool{
@@ -78,71 +72,65 @@ func AgenticScrapeGitHubProfileWorkflow(ctx workflow.Context, prompt string) (Gi
 			"required":             []string{"command"},
 			"additionalProperties": false,
 		},
+		Strict: true,
 	}
 	tools := []Tool{submitTool, ghTool}
 
-	previousResponseID := ""
-	pendingOutputs := map[string]string{}
+	// The conversation lives here rather than with the provider, so any LLM backend works
+	messages := []LLMMessage{{Role: RoleUser, Content: prompt}}
 	maxTurns := 20
 	var githubProfile GitHubProfile
+	var exhausted string // why the budget ran out, once it has
+
+	cfg := OpenAIConfig{
+		Provider: appConfig.ResearchOrchestratorProvider,
+		APIKey:   appConfig.ResearchOrchestratorAPIKey,
+		Model:    appConfig.ResearchOrchestratorModel,
+		APIHost:  appConfig.ResearchOrchestratorBaseURL,
+	}
 
 	for i := 0; i < maxTurns; i++ {
 		logger.Info("Agent turn", "turn", i+1, "maxTurns", maxTurns)
-		var turnResult GenerateResponsesTurnResult
-		var actErr error
+		var turnResult LLMResponse
 
-		cfg := OpenAIConfig{
-			APIKey:  appConfig.ResearchOrchestratorAPIKey,
-			Model:   appConfig.ResearchOrchestratorModel,
-			APIHost: appConfig.ResearchOrchestratorBaseURL,
+		if exhausted != "" {
+			// The forced turn didn't produce a usable profile, and another would cost more
+			return ProfileScrapeResult{}, fmt.Errorf("agent did not submit a profile once out of budget: %s", exhausted)
 		}
-
-		// Add reminder to submit when approaching turn limit OR if we have basic data
-		userPrompt := prompt
-		if i > 0 && i >= maxTurns-3 {
-			userPrompt = "CRITICAL: You are running out of turns. You MUST call 'submit_github_profile' RIGHT NOW with the data you have collected. Do NOT respond with text. Do NOT ask questions. Call submit_github_profile immediately with username, bio, location, website, public_repos, original_repos, forked_repos, languages, top_repositories, contribution_graph, professional_summary, and code_snippets fields."
+		exhausted = budget.Exhausted(usage.Total())
+		if exhausted != "" {
+			messages = append(messages, LLMMessage{Role: RoleUser, Content: fmt.Sprintf("STOP: the research budget is used up (%s). Call 'submit_github_profile' now with the data you have collected so far. Leave fields you have no data for empty.", exhausted)})
+			conversation = append(conversation, fmt.Sprintf("Turn %d: Forcing submission: %s", i+1, exhausted))
+			logger.Warn("LLM budget exhausted, forcing submission", "turn", i+1, "reason", exhausted)
+		} else if i > 0 && i >= maxTurns-3 {
+			// Add reminder to submit when approaching turn limit OR if we have basic data
+			messages = append(messages, LLMMessage{Role: RoleUser, Content: "CRITICAL: You are running out of turns. You MUST call 'submit_github_profile' RIGHT NOW with the data you have collected. Do NOT respond with text. Do NOT ask questions. Call submit_github_profile immediately with username, bio, location, website, public_repos, original_repos, forked_repos, languages, top_repositories, contribution_graph, professional_summary, and code_snippets fields."})
 			logger.Warn("Adding urgent submission reminder", "turn", i+1)
 		} else if i >= 5 {
 			// After 5 turns, start reminding to submit soon
-			userPrompt = "REMINDER: Once you have gathered username, bio, location, top repos, languages, contribution data, and can write a professional summary, you should immediately call 'submit_github_profile'. Do not wait for permission or ask what to do next."
+			messages = append(messages, LLMMessage{Role: RoleUser, Content: "REMINDER: Once you have gathered username, bio, location, top repos, languages, contribution data, and can write a professional summary, you should immediately call 'submit_github_profile'. Do not wait for permission or ask what to do next."})
 			logger.Info("Adding gentle submission reminder", "turn", i+1)
+		} else if i > 0 && messages[len(messages)-1].Role == RoleAssistant {
+			// The model answered with text only; every backend needs a user turn next
+			messages = append(messages, LLMMessage{Role: RoleUser, Content: "Continue: call the tools you need, then call 'submit_github_profile'."})
 		}
 
-		if previousResponseID == "" {
-			input := GenerateResponsesTurnInput{
-				OpenAIConfig:       cfg,
-				PreviousResponseID: previousResponseID,
-				UserInput:          userPrompt,
-				Tools:              tools,
-			}
-			err := workflow.ExecuteActivity(ctx, GenerateResponsesTurnActivity, input).Get(ctx, &turnResult)
-			if err != nil {
-				actErr = err
-			}
-		} else {
-			// For subsequent turns, use userPrompt if we have reminders
-			nextInput := ""
-			if i >= 5 {
-				// Pass the reminder (either gentle or urgent)
-				nextInput = userPrompt
-			}
-			input := GenerateResponsesTurnInput{
-				OpenAIConfig:       cfg,
-				PreviousResponseID: previousResponseID,
-				UserInput:          nextInput,
-				Tools:              tools,
-				FunctionOutputs:    pendingOutputs,
-			}
-			err := workflow.ExecuteActivity(ctx, GenerateResponsesTurnActivity, input).Get(ctx, &turnResult)
-			if err != nil {
-				actErr = err
-			}
+		request := LLMRequest{Messages: messages, Tools: tools}
+		if exhausted != "" {
+			// Offer only the submit tool and require a call, so the last turn can't be spent
+			// on more research
+			request.Tools = []Tool{submitTool}
+			request.ToolChoice = ToolChoiceRequired
 		}
-
-		if actErr != nil {
-			logger.Error("LLM activity failed", "error", actErr)
-			return GitHubProfile{}, actErr
+		input := GenerateLLMTurnInput{
+			OpenAIConfig: cfg,
+			Request:      request,
 		}
+		if err := workflow.ExecuteActivity(ctx, GenerateLLMTurnActivity, input).Get(ctx, &turnResult); err != nil {
+			logger.Error("LLM activity failed", "error", err)
+			return ProfileScrapeResult{}, err
+		}
+		usage.Add(cfg.Model, turnResult.Usage)
 
 		// Check for empty response
 		if strings.TrimSpace(turnResult.Assistant) == "" && len(turnResult.Calls) == 0 {
@@ -151,11 +139,10 @@ func AgenticScrapeGitHubProfileWorkflow(ctx workflow.Context, prompt string) (Gi
 				"responseID", turnResult.ID,
 				"hasAssistant", turnResult.Assistant != "",
 				"numCalls", len(turnResult.Calls))
-			return GitHubProfile{}, fmt.Errorf("LLM returned empty response on turn %d (response ID: %s)", i+1, turnResult.ID)
+			return ProfileScrapeResult{}, fmt.Errorf("LLM returned empty response on turn %d (response ID: %s)", i+1, turnResult.ID)
 		}
 
-		previousResponseID = turnResult.ID
-		pendingOutputs = map[string]string{}
+		messages = append(messages, turnResult.Message())
 		conversation = append(conversation, fmt.Sprintf("Turn %d: Assistant Response: %s", i+1, turnResult.Assistant))
 
 		if len(turnResult.Calls) > 0 {
@@ -178,8 +165,8 @@ func AgenticScrapeGitHubProfileWorkflow(ctx workflow.Context, prompt string) (Gi
 						logger.Error("Failed to parse submit_github_profile arguments", "error", err)
 					} else {
 						githubProfile = profile
-						logger.Info("Exiting agentic loop with profile")
-						return githubProfile, nil
+						logger.Info("Exiting agentic loop with profile", "cost_usd", usage.Total().CostUSD)
+						return ProfileScrapeResult{Profile: githubProfile, Usage: usage, BudgetExhausted: exhausted}, nil
 					}
 				case "gh":
 					var args struct {
@@ -221,7 +208,7 @@ func AgenticScrapeGitHubProfileWorkflow(ctx workflow.Context, prompt string) (Gi
 					toolResult = `{"error": "unknown tool requested"}`
 					logger.Warn("Unknown tool requested", "tool_name", toolCall.Name)
 				}
-				pendingOutputs[toolCall.ID] = toolResult
+				messages = append(messages, LLMMessage{Role: RoleTool, ToolCallID: toolCall.ID, Content: llmToolOutput(toolResult)})
 
 				// Log tool results with adaptive truncation
 				const maxLogLength = 512
@@ -254,5 +241,21 @@ func AgenticScrapeGitHubProfileWorkflow(ctx workflow.Context, prompt string) (Gi
 		// Continue to next turn to see if LLM will call tools
 	}
 
-	return GitHubProfile{}, fmt.Errorf("agentic loop finished without submitting a profile")
+	return ProfileScrapeResult{}, fmt.Errorf("agentic loop finished without submitting a profile")
+}
+
+// maxToolOutputLength bounds each tool result kept in the conversation. The whole
+// conversation is sent with every turn and recorded in workflow history, so a huge
+// paginated listing would otherwise bloat both.
+const maxToolOutputLength = 32 * 1024
+
+// llmToolOutput prepares a tool result for the conversation.
+func llmToolOutput(result string) string {
+	if result == "" {
+		return "(no output)" // some backends reject empty tool results
+	}
+	if len(result) > maxToolOutputLength {
+		return result[:maxToolOutputLength] + "\n... (output truncated; narrow the query, e.g. with --jq)"
+	}
+	return result
 }
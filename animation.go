@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/chai2010/webp"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+)
+
+// Formats for the contribution animation, selected with CONTRIBUTION_ANIMATION.
+const (
+	AnimationGIF  = "gif"
+	AnimationWebP = "webp"
+)
+
+const (
+	// animationRefName is the reference record for the animation, stored in the same
+	// generation folder as the image's reference.
+	animationRefName = "contributions" + refSuffix
+	// Calendar geometry: one column per week, one row per weekday, like GitHub's graph.
+	calendarWeeks  = 53
+	calendarCell   = 12
+	calendarGap    = 3
+	calendarMargin = 24
+	calendarHeader = 36
+	// weekFrameDelay and finalFrameDelay are in milliseconds.
+	weekFrameDelay  = 80
+	finalFrameDelay = 3000
+)
+
+var (
+	animationBackground = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	animationText       = color.RGBA{0x24, 0x29, 0x2f, 0xff}
+	// contributionLevels are GitHub's calendar colors, from no contributions to the most.
+	contributionLevels = []color.RGBA{
+		{0xeb, 0xed, 0xf0, 0xff},
+		{0x9b, 0xe9, 0xa8, 0xff},
+		{0x40, 0xc4, 0x63, 0xff},
+		{0x30, 0xa1, 0x4e, 0xff},
+		{0x21, 0x6e, 0x39, 0xff},
+	}
+)
+
+// ContentAsset is a stored file generated alongside the main image, such as the
+// contribution animation.
+type ContentAsset struct {
+	Key         string `json:"key"`
+	URL         string `json:"url,omitempty"`
+	ContentType string `json:"content_type"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+}
+
+// RenderContributionAnimationInput defines the input for the RenderContributionAnimation activity.
+type RenderContributionAnimationInput struct {
+	StorageBucket string
+	Username      string
+	Graph         ContributionGraph
+	Format        string // AnimationGIF or AnimationWebP
+	// ImageRefKey is the reference record of the image the animation belongs to. The
+	// animation's record is written next to it; without one a new generation folder is used.
+	ImageRefKey string
+}
+
+// RenderContributionAnimation draws the user's contribution calendar filling in week by
+// week, stores it as a blob and records it next to the image's reference.
+func RenderContributionAnimation(ctx context.Context, input RenderContributionAnimationInput) (ContentAsset, error) {
+	if len(input.Graph.Contributions) == 0 {
+		return ContentAsset{}, temporal.NewNonRetryableApplicationError("no contribution data", "NoContributions", nil)
+	}
+
+	frames, delays := contributionFrames(input.Graph)
+	var data []byte
+	var contentType string
+	var err error
+	switch input.Format {
+	case AnimationGIF, "":
+		data, err = encodeAnimatedGIF(frames, delays)
+		contentType = "image/gif"
+	case AnimationWebP:
+		data, err = encodeAnimatedWebP(frames, delays)
+		contentType = "image/webp"
+	default:
+		return ContentAsset{}, temporal.NewNonRetryableApplicationError(fmt.Sprintf("unsupported animation format %q", input.Format), "UnsupportedAnimationFormat", nil)
+	}
+	if err != nil {
+		return ContentAsset{}, fmt.Errorf("failed to encode contribution animation: %w", err)
+	}
+	activity.RecordHeartbeat(ctx, "encoded")
+
+	info := activity.GetInfo(ctx)
+	metadata := map[string]string{
+		MetaGitHubUsername: input.Username,
+		MetaWorkflowID:     info.WorkflowExecution.ID,
+		MetaRunID:          info.WorkflowExecution.RunID,
+		MetaCreatedAt:      time.Now().UTC().Format(time.RFC3339),
+	}
+	key, url, err := putBlob(ctx, appStorage, input.StorageBucket, data, contentType, metadata)
+	if err != nil {
+		return ContentAsset{}, err
+	}
+
+	folder := path.Dir(userRefKey(input.Username))
+	if input.ImageRefKey != "" {
+		folder = path.Dir(input.ImageRefKey)
+	}
+	refKey := folder + "/" + animationRefName
+	ref := ImageRef{Blob: key, ContentType: contentType, Username: input.Username, CreatedAt: time.Now().UTC()}
+	if err := writeImageRef(ctx, appStorage, input.StorageBucket, refKey, ref, metadata); err != nil {
+		return ContentAsset{}, err
+	}
+
+	b := frames[0].Bounds()
+	return ContentAsset{Key: key, URL: url, ContentType: contentType, Width: b.Dx(), Height: b.Dy()}, nil
+}
+
+// contributionFrames renders one frame per week of the year ending on the latest day in
+// graph, each showing the calendar filled up to that week; the last frame is held longer.
+// The window comes from the data rather than the clock so renders are repeatable.
+func contributionFrames(graph ContributionGraph) ([]*image.RGBA, []int) {
+	start, last := calendarWindow(graph.Contributions)
+	thresholds := contributionThresholds(graph.Contributions)
+
+	width := 2*calendarMargin + calendarWeeks*(calendarCell+calendarGap) - calendarGap
+	height := calendarHeader + 2*calendarMargin + 7*(calendarCell+calendarGap) - calendarGap
+	base := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(base, base.Bounds(), image.NewUniform(animationBackground), image.Point{}, draw.Src)
+	if face, err := collageFace(16); err == nil {
+		title := fmt.Sprintf("%d contributions · %d day streak", graph.TotalContributions, graph.Streak)
+		drawCentered(base, face, animationText, truncateToWidth(face, title, width-2*calendarMargin), width/2, calendarMargin+calendarHeader/2)
+		face.Close()
+	}
+
+	cell := func(week, weekday int) image.Rectangle {
+		x := calendarMargin + week*(calendarCell+calendarGap)
+		y := calendarMargin + calendarHeader + weekday*(calendarCell+calendarGap)
+		return image.Rect(x, y, x+calendarCell, y+calendarCell)
+	}
+	// Start from an empty grid and paint one more week into each frame
+	for week := 0; week < calendarWeeks; week++ {
+		for weekday := 0; weekday < 7; weekday++ {
+			draw.Draw(base, cell(week, weekday), image.NewUniform(contributionLevels[0]), image.Point{}, draw.Src)
+		}
+	}
+
+	frames := make([]*image.RGBA, 0, calendarWeeks)
+	delays := make([]int, 0, calendarWeeks)
+	for week := 0; week < calendarWeeks; week++ {
+		for weekday := 0; weekday < 7; weekday++ {
+			day := start.AddDate(0, 0, 7*week+weekday)
+			if day.After(last) {
+				continue
+			}
+			level := contributionLevel(graph.Contributions[day.Format(time.DateOnly)], thresholds)
+			draw.Draw(base, cell(week, weekday), image.NewUniform(contributionLevels[level]), image.Point{}, draw.Src)
+		}
+		frame := image.NewRGBA(base.Bounds())
+		copy(frame.Pix, base.Pix)
+		frames = append(frames, frame)
+		delays = append(delays, weekFrameDelay)
+	}
+	delays[len(delays)-1] = finalFrameDelay
+	return frames, delays
+}
+
+// calendarWindow returns the first day (a Sunday, like GitHub's graph) and the last day of
+// the calendarWeeks-week window ending on the latest day in contributions.
+func calendarWindow(contributions map[string]int) (time.Time, time.Time) {
+	var latest string
+	for day := range contributions {
+		latest = max(latest, day)
+	}
+	last, err := time.Parse(time.DateOnly, latest)
+	if err != nil {
+		last = time.Now().UTC().Truncate(24 * time.Hour)
+	}
+	end := last.AddDate(0, 0, 6-int(last.Weekday()))
+	return end.AddDate(0, 0, -7*calendarWeeks+1), last
+}
+
+// contributionThresholds splits the non-zero daily counts into quartiles, which is how
+// GitHub picks a day's shade.
+func contributionThresholds(contributions map[string]int) []int {
+	var counts []int
+	for _, c := range contributions {
+		if c > 0 {
+			counts = append(counts, c)
+		}
+	}
+	if len(counts) == 0 {
+		return nil
+	}
+	sort.Ints(counts)
+	thresholds := make([]int, len(contributionLevels)-2)
+	for i := range thresholds {
+		thresholds[i] = counts[len(counts)*(i+1)/(len(contributionLevels)-1)]
+	}
+	return thresholds
+}
+
+// contributionLevel returns the index into contributionLevels for a day's count.
+func contributionLevel(count int, thresholds []int) int {
+	if count <= 0 {
+		return 0
+	}
+	level := 1
+	for _, t := range thresholds {
+		if count >= t {
+			level++
+		}
+	}
+	return min(level, len(contributionLevels)-1)
+}
+
+// animationPalette holds the calendar colors plus a gray ramp for anti-aliased text.
+func animationPalette() color.Palette {
+	p := color.Palette{animationBackground, animationText}
+	for _, c := range contributionLevels {
+		p = append(p, c)
+	}
+	for i := 1; i < 15; i++ {
+		v := uint8(0x24 + (0xff-0x24)*i/15)
+		p = append(p, color.RGBA{v, v, v, 0xff})
+	}
+	return p
+}
+
+// encodeAnimatedGIF encodes frames as a looping GIF. delays are in milliseconds.
+func encodeAnimatedGIF(frames []*image.RGBA, delays []int) ([]byte, error) {
+	palette := animationPalette()
+	anim := &gif.GIF{}
+	for i, frame := range frames {
+		paletted := image.NewPaletted(frame.Bounds(), palette)
+		draw.Draw(paletted, paletted.Bounds(), frame, frame.Bounds().Min, draw.Src)
+		anim.Image = append(anim.Image, paletted)
+		anim.Delay = append(anim.Delay, delays[i]/10) // GIF delays are in 1/100 s
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeAnimatedWebP encodes frames as a looping animated WebP. The WebP encoder only
+// writes still images, so each frame is encoded losslessly on its own and its VP8L chunk
+// is wrapped in an ANMF chunk of an extended-format file.
+func encodeAnimatedWebP(frames []*image.RGBA, delays []int) ([]byte, error) {
+	b := frames[0].Bounds()
+	const animationFlag, alphaFlag = 0x02, 0x10
+
+	body := []byte("VP8X")
+	body = binary.LittleEndian.AppendUint32(body, 10)
+	body = append(body, animationFlag|alphaFlag, 0, 0, 0)
+	body = appendUint24(body, uint32(b.Dx()-1))
+	body = appendUint24(body, uint32(b.Dy()-1))
+
+	// ANIM: background color (BGRA) and loop count, 0 meaning forever
+	body = append(body, "ANIM"...)
+	body = binary.LittleEndian.AppendUint32(body, 6)
+	body = append(body, 0xff, 0xff, 0xff, 0xff, 0, 0)
+
+	for i, frame := range frames {
+		var buf bytes.Buffer
+		if err := webp.Encode(&buf, frame, &webp.Options{Lossless: true}); err != nil {
+			return nil, err
+		}
+		still := buf.Bytes()
+		if len(still) < 20 || string(still[12:16]) != "VP8L" {
+			return nil, fmt.Errorf("unexpected WebP frame layout")
+		}
+		bitstream := still[12:] // the VP8L chunk, header included
+
+		// ANMF: frame offset (in 2px units), size minus one, duration, and flags; the
+		// frames are full size and opaque, so neither blending nor disposal matters
+		payload := make([]byte, 0, 16+len(bitstream))
+		payload = appendUint24(payload, 0)
+		payload = appendUint24(payload, 0)
+		payload = appendUint24(payload, uint32(b.Dx()-1))
+		payload = appendUint24(payload, uint32(b.Dy()-1))
+		payload = appendUint24(payload, uint32(delays[i]))
+		payload = append(payload, 0x02) // do not blend
+		payload = append(payload, bitstream...)
+
+		body = append(body, "ANMF"...)
+		body = binary.LittleEndian.AppendUint32(body, uint32(len(payload)))
+		body = append(body, payload...)
+		if len(payload)%2 == 1 {
+			body = append(body, 0)
+		}
+	}
+
+	out := make([]byte, 0, 12+len(body))
+	out = append(out, "RIFF"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(4+len(body)))
+	out = append(out, "WEBP"...)
+	return append(out, body...), nil
+}
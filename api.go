@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"embed"
 	"encoding/base64"
 	"encoding/json"
@@ -91,6 +92,11 @@ func NewTemplateRenderer(logger *slog.Logger) (*TemplateRenderer, error) {
 		return nil, fmt.Errorf("failed to parse poll-details template: %w", err)
 	}
 
+	r.templates["embed-poll"], err = template.ParseFS(templateFS, "templates/embed.html", "templates/embed-poll.html", "templates/poll-results-partial.html")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse embed-poll template: %w", err)
+	}
+
 	r.templates["poll-results-partial"], err = template.ParseFS(templateFS, "templates/poll-results-partial.html")
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse poll-results-partial template: %w", err)
@@ -116,11 +122,21 @@ func NewTemplateRenderer(logger *slog.Logger) (*TemplateRenderer, error) {
 		return nil, fmt.Errorf("failed to parse votes-partial template: %w", err)
 	}
 
+	r.templates["profile-charts-partial"], err = template.ParseFS(templateFS, "templates/profile-charts-partial.html")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse profile-charts-partial template: %w", err)
+	}
+
 	r.templates["poll-list"], err = template.ParseFS(templateFS, "templates/base.html", "templates/poll-list.html")
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse poll-list template: %w", err)
 	}
 
+	r.templates["admin-costs"], err = template.ParseFS(templateFS, "templates/base.html", "templates/admin-costs.html")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse admin-costs template: %w", err)
+	}
+
 	return r, nil
 }
 
@@ -140,7 +156,14 @@ func (r *TemplateRenderer) RenderWithRequest(w http.ResponseWriter, req *http.Re
 		return tmpl.ExecuteTemplate(w, block, data)
 	}
 
-	return tmpl.ExecuteTemplate(w, "base.html", data)
+	// Embed pages are parsed with their own layout instead of the site chrome, and
+	// partials requested directly have no layout at all
+	for _, layout := range []string{"base.html", "embed.html"} {
+		if tmpl.Lookup(layout) != nil {
+			return tmpl.ExecuteTemplate(w, layout, data)
+		}
+	}
+	return tmpl.ExecuteTemplate(w, name, data)
 }
 
 // APIServer for handling HTTP requests
@@ -184,6 +207,11 @@ func (s *APIServer) SetupRoutes() *APIServer {
 	}
 	mux.Handle("GET /static/", http.StripPrefix("/static/", http.FileServer(http.FS(staticSubFS))))
 
+	// Serve stored objects when using local filesystem storage
+	if fsStorage, ok := s.storageProvider.(*FSStorage); ok {
+		mux.Handle("GET /media/", http.StripPrefix("/media", fsStorage))
+	}
+
 	// Home page
 	mux.Handle("GET /", s.handleHomePage())
 	mux.Handle("GET /ping", s.handlePing())
@@ -194,6 +222,9 @@ func (s *APIServer) SetupRoutes() *APIServer {
 	mux.Handle("GET /workflow/{id}/status", s.handleGetWorkflowStatus())
 	mux.Handle("GET /workflow/{id}", s.handleGetWorkflowDetails())
 	mux.Handle("GET /profile/{username}", s.handleGetProfilePage())
+	mux.Handle("GET /profile/{username}/share-image", s.handleGetProfileShareImage())
+	mux.Handle("GET /profile/{username}/contributions", s.handleGetProfileContributions())
+	mux.Handle("GET /profile/{username}/charts", s.handleGetProfileCharts())
 
 	// Poll routes
 	mux.Handle("GET /polls", s.handleListPolls())
@@ -204,11 +235,22 @@ func (s *APIServer) SetupRoutes() *APIServer {
 	mux.Handle("POST /poll/{id}/vote", s.handleVoteOnPoll())
 	mux.Handle("DELETE /poll/{id}", s.handleDeletePoll())
 	mux.Handle("GET /poll/{id}/profile/{option}", s.handleGetPollProfile())
+	mux.Handle("GET /poll/{id}/image/{option}", s.handleGetPollImage())
 	mux.Handle("GET /poll/{id}/votes/{option}", s.handleGetPollVotes())
+	mux.Handle("GET /poll/{id}/share-image", s.handleGetPollShareImage())
+
+	// Link preview routes
+	mux.Handle("GET /oembed", s.handleOEmbed())
+
+	// Embed routes
+	mux.Handle("GET /embed/poll/{id}", s.handleGetEmbedPoll())
 
 	// Visualization routes
 	mux.Handle("GET /visualization-form", s.handleGetVisualizationForm())
 
+	// Admin routes
+	mux.Handle("GET /admin/costs", s.requireAdmin(s.handleGetAdminCosts()))
+
 	// Wrap with middleware (order matters: outer middleware runs first)
 	handler := s.recoveryMiddleware(
 		s.loggingMiddleware(
@@ -301,6 +343,24 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// requireAdmin guards admin pages with HTTP basic auth, the password being ADMIN_TOKEN.
+// Without a token configured the pages don't exist.
+func (s *APIServer) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.AdminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+		_, password, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(s.cfg.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // corsMiddleware adds CORS headers to all responses.
 func (s *APIServer) corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -488,6 +548,13 @@ func (s *APIServer) handleStartContentGeneration() http.Handler {
 			ImageFormat:                   s.cfg.ImageFormat,
 			ImageWidth:                    s.cfg.ImageWidth,
 			ImageHeight:                   s.cfg.ImageHeight,
+			Watermark:                     s.cfg.WatermarkImages,
+			ImageCandidates:               s.cfg.ImageCandidates,
+			ImageMaxAttempts:              s.cfg.ImageMaxAttempts,
+			ImageScorer:                   s.cfg.ImageScorer,
+			UseAvatar:                     s.cfg.ImageUseAvatar,
+			ContributionAnimation:         s.cfg.ContributionAnimation,
+			LLMBudget:                     s.cfg.LLMBudget,
 		}
 
 		if input.ModelName == "" {
@@ -613,6 +680,12 @@ func (s *APIServer) handleGetProfilePage() http.Handler {
 				"Completed": true,
 				"Status":    "Completed",
 				"Result":    result,
+				"Meta":      s.profileMeta(r, username, result),
+				"ChartsURL": "/profile/" + url.PathEscape(username) + "/charts",
+			}
+			if result.Animation != nil {
+				data["Animation"] = result.Animation
+				data["AnimationURL"] = "/profile/" + url.PathEscape(username) + "/contributions"
 			}
 			if err := s.renderer.RenderWithRequest(w, r, "workflow-details", data); err != nil {
 				s.logger.Error("failed to render template", "error", err)
@@ -624,6 +697,33 @@ func (s *APIServer) handleGetProfilePage() http.Handler {
 	})
 }
 
+// handleGetProfileContributions redirects to the contribution animation of a completed profile.
+func (s *APIServer) handleGetProfileContributions() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username := r.PathValue("username")
+		if len(username) > MaxGitHubUsernameLength {
+			s.writeBadRequest(w, r, "Invalid username.")
+			return
+		}
+
+		result, err := s.completedProfileResult(r.Context(), username)
+		if err != nil || result.Animation == nil {
+			http.NotFound(w, r)
+			return
+		}
+		animationURL, err := s.storageProvider.Stat(r.Context(), s.cfg.StorageBucket, result.Animation.Key)
+		if err != nil {
+			s.logger.Debug("Contribution animation not found", "key", result.Animation.Key, "error", err)
+			http.NotFound(w, r)
+			return
+		}
+
+		// The target may be a presigned URL, so only cache the redirect briefly
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		http.Redirect(w, r, animationURL, http.StatusFound)
+	})
+}
+
 // Poll handlers
 
 // handleShowPollForm renders the poll creation form.
@@ -658,12 +758,14 @@ func (s *APIServer) handleCreatePoll() http.Handler {
 		}
 
 		// Use the LLM to parse the poll request.
+		parseCtx, parseUsage := withUsageRecorder(r.Context())
 		parsedRequest, err := ParsePollRequestWithLLM(
-			r.Context(),
+			parseCtx,
 			OpenAIConfig{
-				APIKey:  s.cfg.ResearchOrchestratorAPIKey,
-				Model:   s.cfg.ResearchOrchestratorModel,
-				APIHost: s.cfg.ResearchOrchestratorBaseURL,
+				Provider: s.cfg.ResearchOrchestratorProvider,
+				APIKey:   s.cfg.ResearchOrchestratorAPIKey,
+				Model:    s.cfg.ResearchOrchestratorModel,
+				APIHost:  s.cfg.ResearchOrchestratorBaseURL,
 			},
 			pollRequest,
 		)
@@ -687,10 +789,15 @@ func (s *APIServer) handleCreatePoll() http.Handler {
 			PaymentRequired: s.cfg.PaymentWalletAddr != "", // Only require payment if wallet is configured
 			PaymentWallet:   s.cfg.PaymentWalletAddr,
 			PaymentAmount:   s.cfg.PaymentAmount,
+			// Teardown configuration
+			TeardownMode:     s.cfg.PollTeardownMode,
+			RetentionSeconds: s.cfg.PollRetentionSeconds,
+			// Budget configuration
+			LLMBudget: s.cfg.LLMBudget,
 		}
 
 		// Generate a unique ID for the workflow from the poll question.
-		workflowID := "g2i-poll-" + sanitizeWorkflowID(parsedRequest.Question)
+		workflowID := pollWorkflowIDPrefix + sanitizeWorkflowID(parsedRequest.Question)
 
 		_, err = StartPollWorkflow(s.temporalClient, s.cfg, workflowID, config)
 		if err != nil {
@@ -709,6 +816,11 @@ func (s *APIServer) handleCreatePoll() http.Handler {
 
 		s.logger.Info("successfully started poll workflow", "workflow_id", workflowID)
 
+		rec := UsageRecord{WorkflowID: workflowID, PollID: workflowID, CreatedAt: time.Now().UTC(), Usage: parseUsage.Usage()}
+		if err := writeUsageRecord(r.Context(), s.storageProvider, s.cfg.StorageBucket, workflowID+"-parse", rec); err != nil {
+			s.logger.Warn("failed to record poll parsing usage", "workflow_id", workflowID, "error", err)
+		}
+
 		// Image generation is now handled as a child workflow inside PollWorkflow
 		// Redirect immediately - user doesn't need to wait for image orchestration
 		w.Header().Set("HX-Redirect", "/poll/"+workflowID)
@@ -808,6 +920,7 @@ func (s *APIServer) handleGetPollDetails() http.Handler {
 			"PaymentQRCode": paymentQRCode,
 			"PaymentURL":    paymentURL,
 			"PaymentTxnID":  state.PaymentTxnID,
+			"Meta":          s.pollMeta(r, workflowID, config.Question),
 		}
 
 		if err := s.renderer.RenderWithRequest(w, r, "poll-details", data); err != nil {
@@ -860,11 +973,9 @@ func (s *APIServer) handleGetPollProfile() http.Handler {
 		}
 
 		bucket := s.cfg.StorageBucket
-		imageFormat := s.cfg.ImageFormat
+		key, variants := s.resolvePollImage(r.Context(), workflowID, option)
 
-		key := fmt.Sprintf("%s/%s.%s", workflowID, option, imageFormat)
-
-		s.logger.Debug("Checking for poll image", "bucket", bucket, "key", key, "workflowID", workflowID, "option", option, "imageFormat", imageFormat)
+		s.logger.Debug("Checking for poll image", "bucket", bucket, "key", key, "workflowID", workflowID, "option", option)
 
 		// Stat returns the public URL if the image exists
 		imageURL, err := s.storageProvider.Stat(r.Context(), bucket, key)
@@ -884,9 +995,19 @@ func (s *APIServer) handleGetPollProfile() http.Handler {
 
 		s.logger.Debug("Image found", "bucket", bucket, "key", key, "imageURL", imageURL)
 
+		// Route renditions through the image endpoint so each request gets the best format
+		if variants != nil {
+			imageURL = pollImageURL(workflowID, option, VariantFull)
+			for name, v := range variants {
+				v.URL = pollImageURL(workflowID, option, name)
+				variants[name] = v
+			}
+		}
+
 		// If the image exists, return the image partial
 		data := map[string]interface{}{
 			"ImageURL":   imageURL,
+			"Srcset":     srcset(variants),
 			"Option":     option,
 			"WorkflowID": workflowID,
 		}
@@ -897,6 +1018,78 @@ func (s *APIServer) handleGetPollProfile() http.Handler {
 	})
 }
 
+// handleGetPollImage redirects to a poll option's image in the format that best matches the
+// request's Accept header. The rendition is chosen with ?variant= and defaults to full.
+func (s *APIServer) handleGetPollImage() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		workflowID := r.PathValue("id")
+		option := r.PathValue("option")
+
+		if len(workflowID) > MaxWorkflowIDLength {
+			s.writeBadRequest(w, r, "Invalid poll ID.")
+			return
+		}
+		if len(option) > MaxOptionLength {
+			s.writeBadRequest(w, r, "Invalid option.")
+			return
+		}
+
+		variantName := r.URL.Query().Get("variant")
+		if variantName == "" {
+			variantName = VariantFull
+		}
+
+		key, variants := s.resolvePollImage(r.Context(), workflowID, option)
+		if variants != nil {
+			v, ok := variants[variantName]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			// Prefer the primary format when the client doesn't say otherwise
+			offers := []string{v.ContentType}
+			for _, format := range alternateFormats {
+				if _, ok := v.Alternates[imageContentTypes[format]]; ok {
+					offers = append(offers, imageContentTypes[format])
+				}
+			}
+			key = v.Key
+			if chosen := negotiateImageType(r.Header.Get("Accept"), offers); chosen != v.ContentType {
+				key = v.Alternates[chosen]
+			}
+		}
+
+		imageURL, err := s.storageProvider.Stat(r.Context(), s.cfg.StorageBucket, key)
+		if err != nil {
+			s.logger.Debug("Poll image not found", "key", key, "error", err)
+			http.NotFound(w, r)
+			return
+		}
+
+		// The target may be a presigned URL, so only cache the redirect briefly
+		w.Header().Set("Vary", "Accept")
+		w.Header().Set("Cache-Control", "private, max-age=300")
+		http.Redirect(w, r, imageURL, http.StatusFound)
+	})
+}
+
+// pollImageURL returns the image endpoint URL for a rendition of a poll option.
+func pollImageURL(workflowID, option, variant string) string {
+	return fmt.Sprintf("/poll/%s/image/%s?variant=%s", url.PathEscape(workflowID), url.PathEscape(option), url.QueryEscape(variant))
+}
+
+// resolvePollImage returns the key of the image shown for a poll option, along with
+// its stored variants. Options normally point at shared blobs through a reference
+// record; polls created before references existed hold a physical copy named after the
+// configured image format and have no variants.
+func (s *APIServer) resolvePollImage(ctx context.Context, workflowID, option string) (string, map[string]ImageVariant) {
+	ref, err := readImageRef(ctx, s.storageProvider, s.cfg.StorageBucket, pollRefKey(workflowID, option))
+	if err == nil && ref.Blob != "" {
+		return ref.Blob, ref.Variants
+	}
+	return fmt.Sprintf("%s/%s.%s", workflowID, option, s.cfg.ImageFormat), nil
+}
+
 // handleGetPollVotes handles serving the vote count for a poll option.
 func (s *APIServer) handleGetPollVotes() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -959,13 +1152,15 @@ func (s *APIServer) handleVoteOnPoll() http.Handler {
 		var voterID string
 		if err != nil || voterCookie.Value == "" {
 			voterID = uuid.New().String()
+			sameSite, secure := voterCookieSameSite(r)
 			cookie := &http.Cookie{
 				Name:     "voter_id",
 				Value:    voterID,
 				Expires:  time.Now().Add(365 * 24 * time.Hour),
 				Path:     "/",
 				HttpOnly: true,
-				SameSite: http.SameSiteLaxMode,
+				Secure:   secure,
+				SameSite: sameSite,
 			}
 			http.SetCookie(w, cookie)
 		} else {
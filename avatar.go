@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+)
+
+const (
+	// avatarRefName is the reference record caching a user's avatar inside their folder.
+	// It has no timestamp folder, so gc and GetLatestObjectKeyForUser ignore it.
+	avatarRefName = "avatar" + refSuffix
+	// avatarCacheTTL is how long a cached avatar is used before GitHub is asked again.
+	avatarCacheTTL = 24 * time.Hour
+	// avatarSize is the edge length requested from GitHub; plenty for a reference image.
+	avatarSize = 512
+	// maxAvatarBytes bounds the avatar download.
+	maxAvatarBytes = 5 << 20
+)
+
+// avatarPromptBlock is appended to the generation prompt when the developer's avatar is
+// sent to the image model as a reference.
+const avatarPromptBlock = `
+
+**Reference Image:**
+The attached image is this developer's GitHub avatar. Base the person in the meme on it: keep
+their recognizable features (hair, glasses, facial hair, skin tone, typical clothing) and
+render them in the meme's style. If the avatar is a logo, cartoon or identicon rather than a
+photo, turn it into a character instead of drawing a random person.`
+
+// githubUsernameRe matches valid GitHub logins. Usernames reach a command line, so anything
+// else is rejected before fetching.
+var githubUsernameRe = regexp.MustCompile(`^[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,37}[a-zA-Z0-9])?$`)
+
+// avatarRefKey returns the key of the reference record caching username's avatar.
+func avatarRefKey(username string) string {
+	return username + "/" + avatarRefName
+}
+
+// avatarOptedOut reports whether username asked not to have their avatar used.
+func avatarOptedOut(cfg *Config, username string) bool {
+	return slices.ContainsFunc(cfg.AvatarOptOut, func(u string) bool {
+		return strings.EqualFold(u, username)
+	})
+}
+
+// FetchGitHubAvatarInput defines the input for the FetchGitHubAvatar activity.
+type FetchGitHubAvatarInput struct {
+	StorageBucket string
+	Username      string
+	ModelName     string
+}
+
+// FetchGitHubAvatar stores the user's GitHub avatar as a blob and returns its key, for use
+// as a reference image. It returns "" when the user opted out or the model can't take a
+// reference image, so the caller falls back to text-only generation. Avatars are cached
+// for avatarCacheTTL under the user's folder.
+func FetchGitHubAvatar(ctx context.Context, input FetchGitHubAvatarInput) (string, error) {
+	logger := activity.GetLogger(ctx)
+
+	if avatarOptedOut(appConfig, input.Username) {
+		logger.Info("User opted out of avatar reference images", "username", input.Username)
+		return "", nil
+	}
+	if !acceptsReference(appConfig, input.ModelName) {
+		logger.Info("Image model can't take a reference image", "model", input.ModelName)
+		return "", nil
+	}
+	if !githubUsernameRe.MatchString(input.Username) {
+		return "", temporal.NewNonRetryableApplicationError(fmt.Sprintf("invalid GitHub username %q", input.Username), "InvalidUsername", nil)
+	}
+
+	refKey := avatarRefKey(input.Username)
+	if ref, err := readImageRef(ctx, appStorage, input.StorageBucket, refKey); err == nil && time.Since(ref.CreatedAt) < avatarCacheTTL {
+		return ref.Blob, nil
+	}
+
+	// The username was validated above, so it is safe to pass through the shell
+	out, err := executeGhCommand(ctx, "api users/"+input.Username+" --jq .avatar_url")
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", temporal.NewNonRetryableApplicationError(fmt.Sprintf("gh api users/%s failed: %v", input.Username, err), "GhCommandExecutionError", nil)
+		}
+		return "", err
+	}
+	avatarURL, err := url.Parse(strings.TrimSpace(out))
+	if err != nil || avatarURL.Scheme != "https" {
+		return "", temporal.NewNonRetryableApplicationError(fmt.Sprintf("unexpected avatar URL %q", out), "InvalidAvatarURL", nil)
+	}
+	q := avatarURL.Query()
+	q.Set("s", fmt.Sprint(avatarSize))
+	avatarURL.RawQuery = q.Encode()
+
+	data, err := getBytes(ctx, avatarURL.String())
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxAvatarBytes {
+		return "", temporal.NewNonRetryableApplicationError("avatar is too large", "InvalidAvatar", nil)
+	}
+	contentType := http.DetectContentType(data)
+	if !strings.HasPrefix(contentType, "image/") {
+		return "", temporal.NewNonRetryableApplicationError(fmt.Sprintf("avatar is %s, not an image", contentType), "InvalidAvatar", nil)
+	}
+
+	metadata := map[string]string{
+		MetaGitHubUsername: input.Username,
+		MetaCreatedAt:      time.Now().UTC().Format(time.RFC3339),
+	}
+	key, _, err := putBlob(ctx, appStorage, input.StorageBucket, data, contentType, metadata)
+	if err != nil {
+		return "", err
+	}
+	ref := ImageRef{Blob: key, ContentType: contentType, Username: input.Username, CreatedAt: time.Now().UTC()}
+	if err := writeImageRef(ctx, appStorage, input.StorageBucket, refKey, ref, metadata); err != nil {
+		return "", err
+	}
+	logger.Info("Fetched GitHub avatar", "username", input.Username, "key", key)
+	return key, nil
+}
+
+// readReferenceImage loads a stored image to pass to the image model.
+func readReferenceImage(ctx context.Context, bucket, key string) (*ReferenceImage, error) {
+	rc, info, err := appStorage.Get(ctx, bucket, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reference image %s: %w", key, err)
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, maxAvatarBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reference image %s: %w", key, err)
+	}
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	return &ReferenceImage{Data: data, ContentType: contentType}, nil
+}
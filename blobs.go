@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	// blobFolder is the top-level folder holding content-addressed image bytes.
+	blobFolder = "blobs"
+	// blobPrefix is where blobs live, keyed by the SHA-256 of their contents.
+	blobPrefix = blobFolder + "/sha256/"
+	// refSuffix marks a reference record pointing a user or poll at a blob.
+	refSuffix = ".ref.json"
+	// blobGracePeriod protects freshly written blobs whose reference has not been stored yet.
+	blobGracePeriod = time.Hour
+)
+
+// ImageRef is a small JSON record that maps a user generation or poll option to a blob.
+// Storing refs instead of copies means an image shared by many polls is stored once.
+type ImageRef struct {
+	Blob        string    `json:"blob"`
+	ContentType string    `json:"content_type"`
+	Username    string    `json:"username,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	// Variants maps rendition names (see VariantThumb etc.) to their blobs. URLs are
+	// left out since presigned URLs expire; resolve them when rendering.
+	Variants map[string]ImageVariant `json:"variants,omitempty"`
+	// PerceptualHash is the dHash of the image, used to spot near-duplicates.
+	PerceptualHash string `json:"dhash,omitempty"`
+	// Candidates are the images generated alongside this one, kept so it can be swapped.
+	Candidates []ImageCandidate `json:"candidates,omitempty"`
+}
+
+// blobKeys returns every blob the reference keeps alive: the image, its variants in all
+// formats and any candidates.
+func (r ImageRef) blobKeys() []string {
+	keys := []string{r.Blob}
+	for _, v := range r.Variants {
+		keys = append(keys, v.Key)
+		for _, alt := range v.Alternates {
+			keys = append(keys, alt)
+		}
+	}
+	for _, c := range r.Candidates {
+		keys = append(keys, c.Key)
+	}
+	return keys
+}
+
+// blobKey returns the content-addressed key for data, e.g. blobs/sha256/<hash>.png.
+func blobKey(data []byte, contentType string) string {
+	sum := sha256.Sum256(data)
+	return blobPrefix + hex.EncodeToString(sum[:]) + "." + extensionForContentType(contentType)
+}
+
+// extensionForContentType returns the file extension for a content type like "image/png".
+func extensionForContentType(contentType string) string {
+	parts := strings.Split(contentType, "/")
+	if len(parts) == 2 {
+		return parts[1]
+	}
+	return "jpg"
+}
+
+// userRefKey returns the key for a new reference record under the user's folder.
+func userRefKey(username string) string {
+	return fmt.Sprintf("%s/%d/content%s", username, time.Now().Unix(), refSuffix)
+}
+
+// pollRefKey returns the key of the reference record for a poll option.
+func pollRefKey(pollID, option string) string {
+	return pollID + "/" + option + refSuffix
+}
+
+// putBlob stores data under its content hash unless an identical blob already exists.
+// It returns the blob key and its public URL.
+func putBlob(ctx context.Context, storage ObjectStorage, bucket string, data []byte, contentType string, metadata map[string]string) (string, string, error) {
+	key := blobKey(data, contentType)
+	if url, err := storage.Stat(ctx, bucket, key); err == nil {
+		return key, url, nil
+	}
+	url, err := storage.Put(ctx, bucket, key, bytes.NewReader(data), int64(len(data)), contentType, metadata)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to store blob: %w", err)
+	}
+	return key, url, nil
+}
+
+// writeImageRef stores ref as JSON at key.
+func writeImageRef(ctx context.Context, storage ObjectStorage, bucket, key string, ref ImageRef, metadata map[string]string) error {
+	data, err := json.Marshal(ref)
+	if err != nil {
+		return fmt.Errorf("failed to marshal image ref: %w", err)
+	}
+	if _, err := storage.Put(ctx, bucket, key, bytes.NewReader(data), int64(len(data)), "application/json", metadata); err != nil {
+		return fmt.Errorf("failed to store image ref: %w", err)
+	}
+	return nil
+}
+
+// variantKeys strips URLs from variants so they can be stored in a reference record.
+func variantKeys(variants map[string]ImageVariant) map[string]ImageVariant {
+	if len(variants) == 0 {
+		return nil
+	}
+	keys := make(map[string]ImageVariant, len(variants))
+	for name, v := range variants {
+		v.URL = ""
+		keys[name] = v
+	}
+	return keys
+}
+
+// candidateKeys strips URLs from candidates so they can be stored in a reference record.
+func candidateKeys(candidates []ImageCandidate) []ImageCandidate {
+	if len(candidates) == 0 {
+		return nil
+	}
+	keys := make([]ImageCandidate, len(candidates))
+	for i, c := range candidates {
+		c.URL = ""
+		keys[i] = c
+	}
+	return keys
+}
+
+// readImageRef loads the reference record stored at key.
+func readImageRef(ctx context.Context, storage ObjectStorage, bucket, key string) (ImageRef, error) {
+	var ref ImageRef
+	rc, _, err := storage.Get(ctx, bucket, key)
+	if err != nil {
+		return ref, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return ref, fmt.Errorf("failed to read image ref: %w", err)
+	}
+	if err := json.Unmarshal(data, &ref); err != nil {
+		return ref, fmt.Errorf("failed to decode image ref %s: %w", key, err)
+	}
+	return ref, nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"google.golang.org/genai"
+)
+
+// Names of the built-in candidate scorers, selected with IMAGE_SCORER.
+const (
+	ScorerVision    = "vision"    // a vision model critiques each candidate
+	ScorerHeuristic = "heuristic" // image statistics only; no network, for offline runs and tests
+)
+
+// defaultScorerModel critiques candidates when IMAGE_SCORER_MODEL is unset.
+const defaultScorerModel = "gemini-2.5-flash"
+
+// errNoImageData is returned when the model answers with text instead of an image.
+var errNoImageData = errors.New("no image data returned")
+
+// candidateVariations are appended to the prompt on every attempt after the first so
+// retries don't send the model the identical request again.
+var candidateVariations = []string{
+	"**Variation:** Respond with an image. Go for a bolder, simpler composition.",
+	"**Variation:** Respond with an image. Pick a different meme template than the most obvious one.",
+	"**Variation:** Respond with an image. Use a different art style and color palette.",
+	"**Variation:** Respond with an image. Exaggerate the developer's most distinctive trait.",
+}
+
+// CandidateScorer rates a generated image; the highest-scoring candidate is kept.
+type CandidateScorer interface {
+	// Score returns a score from 0 to 10 and a short rationale.
+	Score(ctx context.Context, prompt string, data []byte, contentType string) (float64, string, error)
+}
+
+// candidateScorers maps IMAGE_SCORER names to constructors.
+var candidateScorers = map[string]func(cfg *Config) CandidateScorer{
+	ScorerVision: func(cfg *Config) CandidateScorer {
+		return &visionScorer{model: cfg.ImageScorerModel}
+	},
+	ScorerHeuristic: func(*Config) CandidateScorer {
+		return heuristicScorer{}
+	},
+}
+
+// newCandidateScorer returns the scorer registered under name. An empty name means vision.
+func newCandidateScorer(name string, cfg *Config) (CandidateScorer, error) {
+	if name == "" {
+		name = ScorerVision
+	}
+	newScorer, ok := candidateScorers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown image scorer %q", name)
+	}
+	return newScorer(cfg), nil
+}
+
+// ImageCandidate records one image the model produced for a profile.
+type ImageCandidate struct {
+	Key         string  `json:"key"` // blob holding the model output
+	URL         string  `json:"url,omitempty"`
+	ContentType string  `json:"content_type"`
+	Attempt     int     `json:"attempt"`
+	Variation   string  `json:"variation,omitempty"` // text appended to the prompt, if any
+	Score       float64 `json:"score"`
+	Rationale   string  `json:"rationale,omitempty"`
+	Chosen      bool    `json:"chosen,omitempty"`
+}
+
+// generatedCandidate is a candidate whose bytes haven't been stored yet.
+type generatedCandidate struct {
+	ImageCandidate
+	Data []byte
+}
+
+// generateCandidates asks the model for up to want images for req in at most maxAttempts
+// calls, varying the prompt after the first attempt, and scores each one with scorer unless
+// it is nil. Candidates come back best first. Attempts are numbered from firstAttempt and
+// across activity retries so a retry doesn't repeat the variations of the previous one.
+func generateCandidates(ctx context.Context, modelName string, req ImageRequest, firstAttempt, want, maxAttempts int, scorer CandidateScorer) ([]generatedCandidate, error) {
+	logger := activity.GetLogger(ctx)
+	offset := firstAttempt + (int(activity.GetInfo(ctx).Attempt)-1)*maxAttempts
+
+	var candidates []generatedCandidate
+	var lastErr error
+	for i := 0; i < maxAttempts && len(candidates) < want; i++ {
+		attempt := offset + i
+		variation := ""
+		if attempt > 0 {
+			variation = candidateVariations[(attempt-1)%len(candidateVariations)]
+		}
+		attemptReq := req
+		if variation != "" {
+			attemptReq.Prompt += "\n\n" + variation
+		}
+
+		data, err := generateImage(ctx, modelName, attemptReq)
+		if err != nil {
+			logger.Warn("Image generation attempt failed", "attempt", attempt+1, "error", err)
+			lastErr = err
+			continue
+		}
+		contentType := http.DetectContentType(data)
+
+		var score float64
+		var rationale string
+		if scorer != nil {
+			if score, rationale, err = scorer.Score(ctx, req.Prompt, data, contentType); err != nil {
+				// An unscored image is still better than none
+				logger.Warn("Failed to score image candidate", "attempt", attempt+1, "error", err)
+				rationale = "scoring failed: " + err.Error()
+			}
+		}
+		candidates = append(candidates, generatedCandidate{
+			ImageCandidate: ImageCandidate{
+				ContentType: contentType,
+				Attempt:     attempt + 1,
+				Variation:   variation,
+				Score:       score,
+				Rationale:   rationale,
+			},
+			Data: data,
+		})
+		activity.RecordHeartbeat(ctx, fmt.Sprintf("candidate %d/%d", len(candidates), want))
+	}
+
+	if len(candidates) == 0 {
+		if errors.Is(lastErr, errNoImageData) {
+			return nil, temporal.NewApplicationError(lastErr.Error(), "NoImageCandidates")
+		}
+		return nil, lastErr
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Score > candidates[j].Score })
+	candidates[0].Chosen = true
+	return candidates, nil
+}
+
+// visionScorer asks a vision model to critique each candidate against the prompt. A
+// scorer is used by a single generation, which scores its candidates one at a time.
+type visionScorer struct {
+	model  string
+	client *genai.Client // created on first use and reused for every candidate
+}
+
+// visionCritiquePrompt instructs the critic; the generation prompt is appended.
+const visionCritiquePrompt = `You are judging an AI-generated meme about a software developer.
+Rate the image from 0 to 10 for how well it matches the brief below, how funny and legible it is,
+and whether it is free of garbled text and visual artifacts.
+Respond with JSON only: {"score": <number>, "rationale": "<one sentence>"}
+
+Brief:
+`
+
+// Score implements CandidateScorer.
+func (s *visionScorer) Score(ctx context.Context, prompt string, data []byte, contentType string) (float64, string, error) {
+	if s.client == nil {
+		client, err := genai.NewClient(ctx, &genai.ClientConfig{HTTPClient: geminiHTTPClient})
+		if err != nil {
+			return 0, "", fmt.Errorf("failed to create genai client: %w", err)
+		}
+		s.client = client
+	}
+
+	contents := []*genai.Content{genai.NewContentFromParts([]*genai.Part{
+		genai.NewPartFromText(visionCritiquePrompt + prompt),
+		genai.NewPartFromBytes(data, contentType),
+	}, genai.RoleUser)}
+	result, err := s.client.Models.GenerateContent(ctx, s.model, contents, &genai.GenerateContentConfig{
+		ResponseMIMEType: "application/json",
+	})
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to critique image: %w", err)
+	}
+	usage := geminiUsage(result.UsageMetadata)
+	usage.Calls = 1
+	recordUsage(ctx, s.model, usage)
+
+	var critique struct {
+		Score     float64 `json:"score"`
+		Rationale string  `json:"rationale"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(result.Text())), &critique); err != nil {
+		return 0, "", fmt.Errorf("failed to parse critique: %w", err)
+	}
+	return math.Max(0, math.Min(10, critique.Score)), critique.Rationale, nil
+}
+
+// heuristicScorer rates images by contrast and resolution. It is crude but deterministic
+// and needs no network, which makes it suitable for offline runs and tests.
+type heuristicScorer struct{}
+
+// Score implements CandidateScorer.
+func (heuristicScorer) Score(_ context.Context, _ string, data []byte, _ string) (float64, string, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to decode candidate: %w", err)
+	}
+	b := img.Bounds()
+
+	// Sample a grid of pixels; flat, washed-out images have a low luminance spread
+	const samples = 64
+	var sum, sumSq float64
+	for y := 0; y < samples; y++ {
+		for x := 0; x < samples; x++ {
+			px := img.At(b.Min.X+x*b.Dx()/samples, b.Min.Y+y*b.Dy()/samples)
+			l := float64(color.GrayModel.Convert(px).(color.Gray).Y) / 255
+			sum += l
+			sumSq += l * l
+		}
+	}
+	n := float64(samples * samples)
+	stddev := math.Sqrt(math.Max(0, sumSq/n-(sum/n)*(sum/n)))
+
+	contrast := math.Min(stddev/0.25, 1)                                 // 0.25 is already a very contrasty image
+	resolution := math.Min(float64(b.Dx()*b.Dy())/float64(1024*1024), 1) // megapixels, capped at 1
+	score := 10 * (0.7*contrast + 0.3*resolution)
+	return score, fmt.Sprintf("contrast %.2f, %dx%d", stddev, b.Dx(), b.Dy()), nil
+}
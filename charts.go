@@ -0,0 +1,331 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"image/color"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Layout of the profile charts, in SVG user units.
+const (
+	chartWidth          = 720
+	calendarLabelWidth  = 30
+	calendarLabelHeight = 16
+	languageBarHeight   = 16
+	maxChartLanguages   = 8
+	maxChartRepos       = 8
+	repoRowHeight       = 28
+	repoNameWidth       = 180
+	repoValueWidth      = 56
+)
+
+// languageColors are GitHub's colors for common languages; others get a stable color
+// derived from their name.
+var languageColors = map[string]string{
+	"Go":         "#00ADD8",
+	"Python":     "#3572A5",
+	"JavaScript": "#f1e05a",
+	"TypeScript": "#3178c6",
+	"Rust":       "#dea584",
+	"Java":       "#b07219",
+	"C":          "#555555",
+	"C++":        "#f34b7d",
+	"C#":         "#178600",
+	"Ruby":       "#701516",
+	"PHP":        "#4F5D95",
+	"Swift":      "#F05138",
+	"Kotlin":     "#A97BFF",
+	"Shell":      "#89e051",
+	"HTML":       "#e34c26",
+	"CSS":        "#563d7c",
+	"Haskell":    "#5e5086",
+	"Elixir":     "#6e4a7e",
+	"Lua":        "#000080",
+	"Zig":        "#ec915c",
+}
+
+// ProfileCharts holds the geometry of the SVG charts shown on a profile page.
+type ProfileCharts struct {
+	Calendar  *CalendarChart
+	Languages *LanguageChart
+	Repos     *RepoChart
+}
+
+// CalendarChart is the contribution calendar: one square per day, one column per week.
+type CalendarChart struct {
+	Width, Height int
+	Cells         []CalendarCell
+	Months        []ChartLabel
+	Weekdays      []ChartLabel
+	Legend        []CalendarCell
+	Total         int
+	Streak        int
+}
+
+// CalendarCell is one day of the calendar, or one legend swatch.
+type CalendarCell struct {
+	X, Y, Size int
+	Color      string
+	Title      string // tooltip
+}
+
+// ChartLabel is a piece of text placed on a chart.
+type ChartLabel struct {
+	X, Y int
+	Text string
+}
+
+// LanguageChart is a single bar split by language.
+type LanguageChart struct {
+	Width, Height int
+	Segments      []LanguageSegment
+	// Source says what the shares are based on, e.g. "top repositories"
+	Source string
+}
+
+// LanguageSegment is one language's share of the bar.
+type LanguageSegment struct {
+	X, Width int
+	Color    string
+	Language string
+	Percent  float64
+}
+
+// RepoChart compares stars and forks of the top repositories as paired horizontal bars.
+type RepoChart struct {
+	Width, Height int
+	BarX          int
+	Rows          []RepoChartRow
+}
+
+// RepoChartRow is one repository's pair of bars.
+type RepoChartRow struct {
+	Y                      int
+	Name                   string
+	Stars, Forks           int
+	StarWidth, ForkWidth   int
+	StarLabelX, ForkLabelX int
+}
+
+// newProfileCharts builds every chart there is data for. Charts without data are nil.
+func newProfileCharts(profile GitHubProfile) ProfileCharts {
+	return ProfileCharts{
+		Calendar:  newCalendarChart(profile.ContributionGraph),
+		Languages: newLanguageChart(profile),
+		Repos:     newRepoChart(profile.TopRepositories),
+	}
+}
+
+// newCalendarChart lays out the same window and shades as the contribution animation.
+func newCalendarChart(graph ContributionGraph) *CalendarChart {
+	if len(graph.Contributions) == 0 {
+		return nil
+	}
+	start, last := calendarWindow(graph.Contributions)
+	thresholds := contributionThresholds(graph.Contributions)
+	step := calendarCell + calendarGap
+
+	chart := &CalendarChart{
+		Width:  calendarLabelWidth + calendarWeeks*step,
+		Height: calendarLabelHeight + 7*step + calendarLabelHeight + calendarGap,
+		Total:  graph.TotalContributions,
+		Streak: graph.Streak,
+	}
+	for weekday, name := range []string{"", "Mon", "", "Wed", "", "Fri", ""} {
+		if name != "" {
+			chart.Weekdays = append(chart.Weekdays, ChartLabel{X: 0, Y: calendarLabelHeight + weekday*step + calendarCell - 2, Text: name})
+		}
+	}
+
+	month := time.Month(0)
+	for week := 0; week < calendarWeeks; week++ {
+		x := calendarLabelWidth + week*step
+		for weekday := 0; weekday < 7; weekday++ {
+			day := start.AddDate(0, 0, 7*week+weekday)
+			if day.After(last) {
+				break
+			}
+			// Label a month at the first column that starts in it, like GitHub does
+			if weekday == 0 && day.Month() != month {
+				month = day.Month()
+				if week < calendarWeeks-2 {
+					chart.Months = append(chart.Months, ChartLabel{X: x, Y: calendarLabelHeight - 4, Text: day.Format("Jan")})
+				}
+			}
+			count := graph.Contributions[day.Format(time.DateOnly)]
+			chart.Cells = append(chart.Cells, CalendarCell{
+				X:     x,
+				Y:     calendarLabelHeight + weekday*step,
+				Size:  calendarCell,
+				Color: hexColor(contributionLevels[contributionLevel(count, thresholds)]),
+				Title: fmt.Sprintf("%s on %s", pluralize(count, "contribution"), day.Format("Jan 2, 2006")),
+			})
+		}
+	}
+
+	legendY := calendarLabelHeight + 7*step
+	for i, level := range contributionLevels {
+		chart.Legend = append(chart.Legend, CalendarCell{
+			X:     chart.Width - (len(contributionLevels)-i)*step,
+			Y:     legendY,
+			Size:  calendarCell,
+			Color: hexColor(level),
+		})
+	}
+	return chart
+}
+
+// newLanguageChart splits the bar by how many top repositories use each language. Without
+// any, the profile's languages share it equally.
+func newLanguageChart(profile GitHubProfile) *LanguageChart {
+	counts := make(map[string]int)
+	for _, repo := range profile.TopRepositories {
+		if repo.Language != "" {
+			counts[repo.Language]++
+		}
+	}
+	source := "top repositories"
+	if len(counts) == 0 {
+		for _, language := range profile.Languages {
+			counts[language] = 1
+		}
+		source = "languages used"
+	}
+	if len(counts) == 0 {
+		return nil
+	}
+
+	languages := make([]string, 0, len(counts))
+	total := 0
+	for language, n := range counts {
+		languages = append(languages, language)
+		total += n
+	}
+	sort.Slice(languages, func(i, j int) bool {
+		if counts[languages[i]] != counts[languages[j]] {
+			return counts[languages[i]] > counts[languages[j]]
+		}
+		return languages[i] < languages[j]
+	})
+
+	// Fold the long tail into "Other" so segments stay wide enough to see
+	if len(languages) > maxChartLanguages {
+		other := 0
+		for _, language := range languages[maxChartLanguages-1:] {
+			other += counts[language]
+		}
+		languages = append(languages[:maxChartLanguages-1], "Other")
+		counts["Other"] = other
+	}
+
+	chart := &LanguageChart{Width: chartWidth, Height: languageBarHeight, Source: source}
+	x := 0
+	for i, language := range languages {
+		width := chartWidth * counts[language] / total
+		if i == len(languages)-1 {
+			width = chartWidth - x // absorb rounding
+		}
+		chart.Segments = append(chart.Segments, LanguageSegment{
+			X:        x,
+			Width:    width,
+			Color:    languageColor(language),
+			Language: language,
+			Percent:  100 * float64(counts[language]) / float64(total),
+		})
+		x += width
+	}
+	return chart
+}
+
+// newRepoChart draws stars and forks of the most-starred repositories on a shared scale.
+func newRepoChart(repos []Repository) *RepoChart {
+	if len(repos) == 0 {
+		return nil
+	}
+	sorted := append([]Repository(nil), repos...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Stars > sorted[j].Stars })
+	sorted = sorted[:min(len(sorted), maxChartRepos)]
+
+	peak := 1
+	for _, repo := range sorted {
+		peak = max(peak, repo.Stars, repo.Forks)
+	}
+	barX := repoNameWidth
+	barSpace := chartWidth - barX - repoValueWidth
+	scale := func(n int) int {
+		if n == 0 {
+			return 0
+		}
+		return max(2, barSpace*n/peak) // keep small values visible
+	}
+
+	chart := &RepoChart{Width: chartWidth, Height: len(sorted) * repoRowHeight, BarX: barX}
+	for i, repo := range sorted {
+		row := RepoChartRow{
+			Y:         i * repoRowHeight,
+			Name:      truncateString(repo.Name, 24),
+			Stars:     repo.Stars,
+			Forks:     repo.Forks,
+			StarWidth: scale(repo.Stars),
+			ForkWidth: scale(repo.Forks),
+		}
+		row.StarLabelX = barX + row.StarWidth + 4
+		row.ForkLabelX = barX + row.ForkWidth + 4
+		chart.Rows = append(chart.Rows, row)
+	}
+	return chart
+}
+
+// languageColor returns GitHub's color for language, or a stable color derived from its name.
+func languageColor(language string) string {
+	if c, ok := languageColors[language]; ok {
+		return c
+	}
+	if language == "Other" {
+		return "#9ca3af"
+	}
+	h := fnv.New32a()
+	h.Write([]byte(language))
+	sum := h.Sum32()
+	return hexColor(color.RGBA{uint8(sum>>16)/2 + 64, uint8(sum>>8)/2 + 64, uint8(sum)/2 + 64, 0xff})
+}
+
+// hexColor formats c as #rrggbb.
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// pluralize returns "1 thing" or "n things".
+func pluralize(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// handleGetProfileCharts renders the SVG charts of a completed profile as an HTMX partial.
+func (s *APIServer) handleGetProfileCharts() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username := r.PathValue("username")
+		if len(username) > MaxGitHubUsernameLength {
+			s.writeBadRequest(w, r, "Invalid username.")
+			return
+		}
+
+		result, err := s.completedProfileResult(r.Context(), username)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		// Profiles only change when regenerated, which replaces the workflow result
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		if err := s.renderer.RenderWithRequest(w, r, "profile-charts-partial", newProfileCharts(result.GitHubProfile)); err != nil {
+			s.logger.Error("failed to render template", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	})
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+
+	"go.temporal.io/sdk/activity"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// collageName is the object name of a poll's share image inside its folder.
+const collageName = "collage.png"
+
+// The collage matches the og variant so it can be used as a link preview as is.
+const (
+	collageWidth    = ogWidth
+	collageHeight   = ogHeight
+	collageMargin   = 24
+	collageHeader   = 96 // question band at the top
+	collageLabel    = 56 // username band under each panel
+	collageGutter   = 72 // space between panels, holding the "VS" badge
+	questionSize    = 44
+	minQuestionSize = 24
+	labelSize       = 30
+	versusSize      = 36
+)
+
+var (
+	collageBackground = color.RGBA{0x11, 0x18, 0x27, 0xff}
+	collageForeground = color.RGBA{0xf9, 0xfa, 0xfb, 0xff}
+	collageAccent     = color.RGBA{0xf5, 0x9e, 0x0b, 0xff}
+)
+
+// collageKey returns the key of a poll's collage image.
+func collageKey(pollID string) string {
+	return pollID + "/" + collageName
+}
+
+// ComposePollCollageInput defines the input for the ComposePollCollage activity.
+type ComposePollCollageInput struct {
+	Bucket    string
+	PollID    string
+	Question  string
+	Usernames []string
+}
+
+// ComposePollCollage draws every option image of a poll side by side as a "versus" card,
+// labeled with the usernames and the poll question, and stores it at pollID/collage.png.
+// It returns the collage's key.
+func ComposePollCollage(ctx context.Context, input ComposePollCollageInput) (string, error) {
+	logger := activity.GetLogger(ctx)
+
+	images := make([]image.Image, 0, len(input.Usernames))
+	for _, username := range input.Usernames {
+		img, err := loadPollOptionImage(ctx, input.Bucket, input.PollID, username)
+		if err != nil {
+			return "", err
+		}
+		images = append(images, img)
+		activity.RecordHeartbeat(ctx, username)
+	}
+
+	collage, err := renderCollage(input.Question, input.Usernames, images)
+	if err != nil {
+		return "", err
+	}
+	data, contentType, err := encodeImage(collage, "png")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode collage: %w", err)
+	}
+
+	key := collageKey(input.PollID)
+	if _, err := appStorage.Put(ctx, input.Bucket, key, bytes.NewReader(data), int64(len(data)), contentType, nil); err != nil {
+		return "", fmt.Errorf("failed to store collage: %w", err)
+	}
+	logger.Info("Stored poll collage", "key", key, "options", len(images))
+	return key, nil
+}
+
+// loadPollOptionImage decodes the image a poll option points at, preferring the card
+// variant since the collage never needs more pixels than that.
+func loadPollOptionImage(ctx context.Context, bucket, pollID, username string) (image.Image, error) {
+	ref, err := readImageRef(ctx, appStorage, bucket, pollRefKey(pollID, username))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image ref for %s: %w", username, err)
+	}
+	key := ref.Blob
+	if card, ok := ref.Variants[VariantCard]; ok && card.Key != "" {
+		key = card.Key
+	}
+
+	rc, _, err := appStorage.Get(ctx, bucket, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image for %s: %w", username, err)
+	}
+	defer rc.Close()
+
+	img, _, err := image.Decode(rc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image for %s: %w", username, err)
+	}
+	return img, nil
+}
+
+// renderCollage lays images out in a row under the question, with each username below
+// its image and a "VS" badge in the gutter between neighbours.
+func renderCollage(question string, usernames []string, images []image.Image) (image.Image, error) {
+	if len(images) == 0 {
+		return nil, fmt.Errorf("collage needs at least one image")
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, collageWidth, collageHeight))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(collageBackground), image.Point{}, draw.Src)
+
+	questionFace, err := collageFace(fittingSize(question, collageWidth-2*collageMargin, questionSize, minQuestionSize))
+	if err != nil {
+		return nil, err
+	}
+	labelFace, err := collageFace(labelSize)
+	if err != nil {
+		return nil, err
+	}
+	versusFace, err := collageFace(versusSize)
+	if err != nil {
+		return nil, err
+	}
+
+	drawCentered(dst, questionFace, collageForeground, truncateToWidth(questionFace, question, collageWidth-2*collageMargin), collageWidth/2, collageMargin+collageHeader/2)
+
+	n := len(images)
+	panelTop := collageMargin + collageHeader
+	panelHeight := collageHeight - panelTop - collageLabel - collageMargin
+	panelWidth := (collageWidth - 2*collageMargin - (n-1)*collageGutter) / n
+
+	for i, img := range images {
+		x := collageMargin + i*(panelWidth+collageGutter)
+		panel := image.Rect(x, panelTop, x+panelWidth, panelTop+panelHeight)
+		draw.Draw(dst, panel, coverCrop(img, panel.Dx(), panel.Dy()), image.Point{}, draw.Src)
+
+		label := "@" + usernames[i]
+		drawCentered(dst, labelFace, collageForeground, truncateToWidth(labelFace, label, panelWidth), x+panelWidth/2, panel.Max.Y+collageLabel/2)
+
+		if i > 0 {
+			drawCentered(dst, versusFace, collageAccent, "VS", x-collageGutter/2, panelTop+panelHeight/2)
+		}
+	}
+
+	return dst, nil
+}
+
+// collageFace returns the bold Go font at size points.
+func collageFace(size float64) (font.Face, error) {
+	f, err := opentype.Parse(gobold.TTF)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse collage font: %w", err)
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create collage font face: %w", err)
+	}
+	return face, nil
+}
+
+// fittingSize returns the largest size between maxSize and minSize at which text fits in
+// width, or minSize if it doesn't fit at all.
+func fittingSize(text string, width int, maxSize, minSize float64) float64 {
+	for size := maxSize; size > minSize; size -= 2 {
+		face, err := collageFace(size)
+		if err != nil {
+			break
+		}
+		if font.MeasureString(face, text).Ceil() <= width {
+			return size
+		}
+	}
+	return minSize
+}
+
+// truncateToWidth shortens text with an ellipsis until it fits in width.
+func truncateToWidth(face font.Face, text string, width int) string {
+	if font.MeasureString(face, text).Ceil() <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 {
+		runes = runes[:len(runes)-1]
+		candidate := strings.TrimSpace(string(runes)) + "…"
+		if font.MeasureString(face, candidate).Ceil() <= width {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// drawCentered draws text centered horizontally on cx and vertically on cy.
+func drawCentered(dst draw.Image, face font.Face, c color.Color, text string, cx, cy int) {
+	metrics := face.Metrics()
+	d := font.Drawer{Dst: dst, Src: image.NewUniform(c), Face: face}
+	width := d.MeasureString(text)
+	baseline := fixed.I(cy) + (metrics.Ascent-metrics.Descent)/2
+	d.Dot = fixed.Point26_6{X: fixed.I(cx) - width/2, Y: baseline}
+	d.DrawString(text)
+}
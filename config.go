@@ -2,8 +2,10 @@ package main
 
 import (
 	"fmt"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 )
 
 // Config holds all application configuration loaded from environment variables
@@ -26,10 +28,16 @@ type Config struct {
 	S3SecretKey      string
 	S3UseSSL         bool
 
+	// Local Filesystem Storage Configuration
+	FSRoot       string
+	FSPublicURL  string
+	FSSigningKey string
+
 	// AWS Configuration (for native S3)
-	AWSRegion    string
-	AWSAccessKey string
-	AWSSecretKey string
+	AWSRegion     string
+	AWSAccessKey  string
+	AWSSecretKey  string
+	AWSS3Endpoint string
 
 	// GCS Configuration
 	GCSProjectID       string
@@ -39,15 +47,37 @@ type Config struct {
 	GoogleAPIKey string
 	GeminiModel  string
 
+	// Other Image Providers, selected by the GEMINI_MODEL prefix (see NewImageGenerator)
+	OpenAIAPIKey   string
+	OpenAIBaseURL  string
+	SDBaseURL      string
+	ComfyUIBaseURL string
+
 	// LLM Orchestrator Configuration
-	ResearchOrchestratorAPIKey  string
-	ResearchOrchestratorModel   string
-	ResearchOrchestratorBaseURL string
+	ResearchOrchestratorProvider string
+	ResearchOrchestratorAPIKey   string
+	ResearchOrchestratorModel    string
+	ResearchOrchestratorBaseURL  string
 
 	// Image Generation Configuration
 	ImageFormat string
 	ImageWidth  int
 	ImageHeight int
+	// WatermarkImages stamps generated images with a visible watermark and provenance metadata
+	WatermarkImages bool
+	// ImageCandidates images are generated per profile in at most ImageMaxAttempts model
+	// calls, and ImageScorer picks the winner
+	ImageCandidates  int
+	ImageMaxAttempts int
+	ImageScorer      string
+	ImageScorerModel string
+	// ImageUseAvatar passes each developer's GitHub avatar to the image model, except for
+	// the usernames in AvatarOptOut
+	ImageUseAvatar bool
+	AvatarOptOut   []string
+	// ContributionAnimation is the format of the contribution calendar animation shown on
+	// profile pages, or "off"
+	ContributionAnimation string
 
 	// Payment Configuration
 	ForohtooServerURL  string
@@ -55,16 +85,33 @@ type Config struct {
 	PaymentWalletAddr  string
 	PaymentAmount      float64
 
+	// Poll Teardown Configuration
+	PollTeardownMode     string
+	PollRetentionSeconds int
+
 	// System Prompts
 	ResearchAgentPrompt      string
 	ContentGenerationPrompt  string
 	PollParserPrompt         string
 
 	// Server Configuration
-	Port string
+	Port                string
+	PublicBaseURL       string
+	EmbedAllowedOrigins []string
 
 	// GitHub Token
 	GitHubToken string
+
+	// Cost Accounting: ModelPrices is defaultModelPrices plus MODEL_PRICES_FILE, and
+	// AdminToken protects /admin pages (disabled when empty)
+	ModelPrices map[string]ModelPrice
+	AdminToken  string
+	// LLMBudget is the default research agent budget for profiles and poll options
+	LLMBudget LLMBudget
+
+	// Fixtures: FixtureMode is "", FixtureRecord or FixtureReplay
+	FixtureMode string
+	FixtureDir  string
 }
 
 // LoadConfig loads and validates all required environment variables
@@ -105,6 +152,20 @@ func LoadConfig() (*Config, error) {
 		return intVal
 	}
 
+	// Helper to get optional int env var with default
+	getOptionalInt := func(key string, defaultVal int) int {
+		val := os.Getenv(key)
+		if val == "" {
+			return defaultVal
+		}
+		intVal, err := strconv.Atoi(val)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s must be a valid integer: %v", key, err))
+			return defaultVal
+		}
+		return intVal
+	}
+
 	// Helper to get optional float env var with default
 	getOptionalFloat := func(key string, defaultVal float64) float64 {
 		val := os.Getenv(key)
@@ -137,28 +198,80 @@ func LoadConfig() (*Config, error) {
 	cfg.S3SecretKey = os.Getenv("S3_SECRET_KEY")
 	cfg.S3UseSSL = os.Getenv("S3_USE_SSL") == "true"
 
+	// Local Filesystem Storage Configuration (optional, only needed for fs)
+	cfg.FSRoot = getOptional("STORAGE_FS_ROOT", "objects")
+	cfg.FSSigningKey = os.Getenv("STORAGE_FS_SIGNING_KEY")
+
 	// AWS Configuration (optional, only needed for native AWS S3)
 	cfg.AWSRegion = os.Getenv("AWS_REGION")
 	cfg.AWSAccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
 	cfg.AWSSecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	cfg.AWSS3Endpoint = os.Getenv("AWS_S3_ENDPOINT") // optional override, e.g. http://localhost:9000
 
 	// GCS Configuration (optional, only needed for GCS)
 	cfg.GCSProjectID = os.Getenv("GCS_PROJECT_ID")
 	cfg.GCSCredentialsPath = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
 
-	// Google AI Configuration (required)
-	cfg.GoogleAPIKey = getRequired("GOOGLE_API_KEY")
+	// Image Model Configuration (required). GOOGLE_API_KEY is checked below, once we know
+	// whether anything uses Gemini.
+	cfg.GoogleAPIKey = os.Getenv("GOOGLE_API_KEY")
 	cfg.GeminiModel = getRequired("GEMINI_MODEL")
+	cfg.OpenAIAPIKey = os.Getenv("OPENAI_API_KEY")
+	cfg.OpenAIBaseURL = getOptional("OPENAI_BASE_URL", "https://api.openai.com")
+	cfg.SDBaseURL = getOptional("SD_BASE_URL", "http://127.0.0.1:7860")
+	cfg.ComfyUIBaseURL = getOptional("COMFYUI_BASE_URL", "http://127.0.0.1:8188")
 
 	// LLM Orchestrator Configuration (required)
-	cfg.ResearchOrchestratorAPIKey = getRequired("RESEARCH_ORCHESTRATOR_LLM_API_KEY")
+	cfg.ResearchOrchestratorProvider = getOptional("RESEARCH_ORCHESTRATOR_LLM_PROVIDER", LLMProviderResponses)
+	if _, ok := llmProviders[cfg.ResearchOrchestratorProvider]; !ok {
+		errs = append(errs, fmt.Sprintf("RESEARCH_ORCHESTRATOR_LLM_PROVIDER must be %q, %q, %q or %q", LLMProviderResponses, LLMProviderChat, LLMProviderOllama, LLMProviderAnthropic))
+	}
+	if cfg.ResearchOrchestratorProvider == LLMProviderOllama {
+		cfg.ResearchOrchestratorAPIKey = os.Getenv("RESEARCH_ORCHESTRATOR_LLM_API_KEY") // local servers don't need one
+	} else {
+		cfg.ResearchOrchestratorAPIKey = getRequired("RESEARCH_ORCHESTRATOR_LLM_API_KEY")
+	}
 	cfg.ResearchOrchestratorModel = getRequired("RESEARCH_ORCHESTRATOR_LLM_MODEL")
 	cfg.ResearchOrchestratorBaseURL = getRequired("RESEARCH_ORCHESTRATOR_LLM_BASE_URL")
 
 	// Image Generation Configuration (required)
 	cfg.ImageFormat = getRequired("IMAGE_FORMAT")
+	if err := checkImageFormat(cfg.ImageFormat); err != nil {
+		errs = append(errs, fmt.Sprintf("IMAGE_FORMAT: %v", err))
+	}
 	cfg.ImageWidth = getRequiredInt("IMAGE_WIDTH")
 	cfg.ImageHeight = getRequiredInt("IMAGE_HEIGHT")
+	cfg.WatermarkImages = os.Getenv("WATERMARK_IMAGES") == "true"
+	cfg.ImageCandidates = getOptionalInt("IMAGE_CANDIDATES", 1)
+	cfg.ImageMaxAttempts = getOptionalInt("IMAGE_MAX_ATTEMPTS", cfg.ImageCandidates+2)
+	cfg.ImageScorer = getOptional("IMAGE_SCORER", ScorerVision)
+	if _, ok := candidateScorers[cfg.ImageScorer]; !ok {
+		errs = append(errs, fmt.Sprintf("IMAGE_SCORER must be %q or %q", ScorerVision, ScorerHeuristic))
+	}
+	cfg.ImageScorerModel = getOptional("IMAGE_SCORER_MODEL", defaultScorerModel)
+	cfg.ImageUseAvatar = os.Getenv("IMAGE_USE_AVATAR") == "true"
+	for _, username := range strings.Split(os.Getenv("AVATAR_OPT_OUT"), ",") {
+		if username = strings.TrimSpace(username); username != "" {
+			cfg.AvatarOptOut = append(cfg.AvatarOptOut, username)
+		}
+	}
+	cfg.ContributionAnimation = getOptional("CONTRIBUTION_ANIMATION", "off")
+	switch cfg.ContributionAnimation {
+	case AnimationGIF, AnimationWebP:
+	case "off":
+		cfg.ContributionAnimation = ""
+	default:
+		errs = append(errs, fmt.Sprintf("CONTRIBUTION_ANIMATION must be %q, %q or \"off\"", AnimationGIF, AnimationWebP))
+	}
+	generator, _ := NewImageGenerator(cfg, cfg.GeminiModel)
+	_, geminiImages := generator.(geminiImageGenerator)
+	visionScoring := cfg.ImageCandidates > 1 && cfg.ImageScorer == ScorerVision
+	if cfg.GoogleAPIKey == "" && (geminiImages || visionScoring) {
+		errs = append(errs, "GOOGLE_API_KEY is required for Gemini image models and the vision scorer")
+	}
+	if _, openAIImages := generator.(*openAIImageGenerator); openAIImages && cfg.OpenAIAPIKey == "" {
+		errs = append(errs, "OPENAI_API_KEY is required for OpenAI image models")
+	}
 
 	// Payment Configuration (required)
 	cfg.ForohtooServerURL = getRequired("FOROHTOO_SERVER_URL")
@@ -166,6 +279,15 @@ func LoadConfig() (*Config, error) {
 	cfg.PaymentWalletAddr = getRequired("PAYMENT_WALLET_ADDRESS")
 	cfg.PaymentAmount = getOptionalFloat("PAYMENT_AMOUNT", 0.01)
 
+	// Poll Teardown Configuration (optional, polls are kept forever by default)
+	cfg.PollTeardownMode = os.Getenv("POLL_TEARDOWN_MODE")
+	switch cfg.PollTeardownMode {
+	case "", PollTeardownArchive, PollTeardownDelete:
+	default:
+		errs = append(errs, fmt.Sprintf("POLL_TEARDOWN_MODE must be %q or %q", PollTeardownArchive, PollTeardownDelete))
+	}
+	cfg.PollRetentionSeconds = getOptionalInt("POLL_RETENTION_SECONDS", 86400)
+
 	// System Prompts (required)
 	cfg.ResearchAgentPrompt = getRequired("RESEARCH_AGENT_SYSTEM_PROMPT")
 	cfg.ContentGenerationPrompt = getRequired("CONTENT_GENERATION_SYSTEM_PROMPT")
@@ -173,10 +295,54 @@ func LoadConfig() (*Config, error) {
 
 	// Server Configuration
 	cfg.Port = getOptional("PORT", "8080")
+	cfg.PublicBaseURL = os.Getenv("PUBLIC_BASE_URL") // optional, e.g. https://vibecheck.example.com
+	for _, origin := range strings.Split(os.Getenv("EMBED_ALLOWED_ORIGINS"), ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "" {
+			continue
+		}
+		if origin != "*" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+				errs = append(errs, fmt.Sprintf("EMBED_ALLOWED_ORIGINS: %q must be \"*\" or an origin like https://wiki.example.com", origin))
+				continue
+			}
+		}
+		cfg.EmbedAllowedOrigins = append(cfg.EmbedAllowedOrigins, origin)
+	}
+	cfg.FSPublicURL = getOptional("STORAGE_FS_PUBLIC_URL", "http://localhost:"+cfg.Port+"/media")
 
 	// GitHub Token (optional for now, but probably should be required)
 	cfg.GitHubToken = os.Getenv("GH_TOKEN")
 
+	// Cost Accounting (optional)
+	cfg.ModelPrices = make(map[string]ModelPrice, len(defaultModelPrices))
+	for model, price := range defaultModelPrices {
+		cfg.ModelPrices[model] = price
+	}
+	if path := os.Getenv("MODEL_PRICES_FILE"); path != "" {
+		if err := loadModelPrices(path, cfg.ModelPrices); err != nil {
+			errs = append(errs, fmt.Sprintf("MODEL_PRICES_FILE: %v", err))
+		}
+	}
+	cfg.AdminToken = os.Getenv("ADMIN_TOKEN")
+	cfg.LLMBudget = LLMBudget{
+		MaxTokens:  getOptionalInt("LLM_TOKEN_BUDGET", 0),
+		MaxCostUSD: getOptionalFloat("LLM_COST_BUDGET_USD", 0),
+	}
+	if cfg.LLMBudget.MaxTokens < 0 || cfg.LLMBudget.MaxCostUSD < 0 {
+		errs = append(errs, "LLM_TOKEN_BUDGET and LLM_COST_BUDGET_USD must not be negative")
+	}
+
+	// Fixtures (optional, for recording sessions and replaying them offline)
+	cfg.FixtureMode = os.Getenv("FIXTURE_MODE")
+	switch cfg.FixtureMode {
+	case "", FixtureRecord, FixtureReplay:
+	default:
+		errs = append(errs, fmt.Sprintf("FIXTURE_MODE must be %q or %q", FixtureRecord, FixtureReplay))
+	}
+	cfg.FixtureDir = getOptional("FIXTURE_DIR", "testdata/fixtures")
+
 	// If there were any validation errors, return them all at once
 	if len(errs) > 0 {
 		return nil, fmt.Errorf("configuration validation failed:\n  - %s", joinErrors(errs))
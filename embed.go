@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+)
+
+// embedPathPrefix is the route prefix of pages meant to be framed by other sites.
+const embedPathPrefix = "/embed/"
+
+// frameAncestors returns the CSP frame-ancestors source list allowing the configured
+// EMBED_ALLOWED_ORIGINS to frame embed pages. Without any, only this site may frame them.
+func (s *APIServer) frameAncestors() string {
+	sources := []string{"'self'"}
+	for _, origin := range s.cfg.EmbedAllowedOrigins {
+		if origin == "*" {
+			return "*"
+		}
+		sources = append(sources, origin)
+	}
+	return strings.Join(sources, " ")
+}
+
+// isEmbedRequest reports whether an HTMX request was made from an embed page.
+func isEmbedRequest(r *http.Request) bool {
+	current, err := url.Parse(r.Header.Get("HX-Current-URL"))
+	return err == nil && strings.HasPrefix(current.Path, embedPathPrefix)
+}
+
+// voterCookieSameSite returns the SameSite mode for the voter cookie. Embedded polls run in
+// a third-party context where browsers only send SameSite=None cookies, which must be Secure.
+func voterCookieSameSite(r *http.Request) (http.SameSite, bool) {
+	if isEmbedRequest(r) {
+		return http.SameSiteNoneMode, true
+	}
+	return http.SameSiteLaxMode, false
+}
+
+// handleGetEmbedPoll renders a poll without the site chrome so it can be framed by the
+// origins in EMBED_ALLOWED_ORIGINS. Images, counts and voting are served by the same
+// partials and routes as the poll page.
+func (s *APIServer) handleGetEmbedPoll() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		workflowID := r.PathValue("id")
+		if len(workflowID) > MaxWorkflowIDLength {
+			s.writeBadRequest(w, r, "Invalid poll ID.")
+			return
+		}
+
+		w.Header().Set("Content-Security-Policy", "frame-ancestors "+s.frameAncestors())
+
+		desc, err := GetWorkflowDescription(s.temporalClient, workflowID)
+		if err != nil {
+			var notFoundErr *serviceerror.NotFound
+			if errors.As(err, &notFoundErr) {
+				http.NotFound(w, r)
+				return
+			}
+			s.writeInternalError(w, r, err.Error())
+			return
+		}
+		if desc.WorkflowExecutionInfo.Status != enums.WORKFLOW_EXECUTION_STATUS_RUNNING {
+			http.NotFound(w, r)
+			return
+		}
+
+		config, err := QueryPollWorkflow[PollConfig](s.temporalClient, workflowID, "get_config")
+		if err != nil {
+			s.writeInternalError(w, r, err.Error())
+			return
+		}
+		options, err := QueryPollWorkflow[[]string](s.temporalClient, workflowID, "get_options")
+		if err != nil {
+			s.writeInternalError(w, r, err.Error())
+			return
+		}
+		state, err := QueryPollWorkflow[PollState](s.temporalClient, workflowID, "get_state")
+		if err != nil {
+			s.writeInternalError(w, r, err.Error())
+			return
+		}
+
+		data := map[string]interface{}{
+			"Title":       config.Question,
+			"WorkflowID":  workflowID,
+			"Config":      config,
+			"Options":     options,
+			"PaymentPaid": state.PaymentPaid,
+			"PollURL":     s.baseURL(r) + "/poll/" + url.PathEscape(workflowID),
+		}
+
+		if err := s.renderer.RenderWithRequest(w, r, "embed-poll", data); err != nil {
+			s.logger.Error("failed to render template", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	})
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.temporal.io/sdk/temporal"
+)
+
+// Fixture modes, selected with FIXTURE_MODE. Recording calls the real services and saves
+// every request/response pair; replaying answers from the saved pairs and never touches
+// the network or the gh CLI.
+const (
+	FixtureRecord = "record"
+	FixtureReplay = "replay"
+)
+
+// Kinds of recorded calls; each gets its own subdirectory of the fixture directory.
+const (
+	fixtureKindLLM   = "llm"
+	fixtureKindImage = "image"
+	fixtureKindGh    = "gh"
+)
+
+// appFixtures is set when FIXTURE_MODE is, and intercepts LLM, image model and gh calls.
+var appFixtures *fixtureStore
+
+// fixtureStore reads and writes fixture files under dir/<kind>/<hash>.json.
+type fixtureStore struct {
+	mode string
+	dir  string
+}
+
+// fixture is one recorded call. Request holds the normalized request the key is derived
+// from, so a miss can be diagnosed by diffing files.
+type fixture struct {
+	Kind     string          `json:"kind"`
+	Key      string          `json:"key"`
+	Request  json.RawMessage `json:"request"`
+	Response json.RawMessage `json:"response,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// installFixtures routes the LLM, image model and Gemini HTTP clients through the fixture
+// store when cfg.FixtureMode is set. Call it once at startup, before any activity runs.
+func installFixtures(cfg *Config) error {
+	if cfg.FixtureMode == "" {
+		return nil
+	}
+	if cfg.FixtureMode == FixtureRecord {
+		if err := os.MkdirAll(cfg.FixtureDir, 0o755); err != nil {
+			return fmt.Errorf("failed to create fixture directory: %w", err)
+		}
+	}
+	appFixtures = &fixtureStore{mode: cfg.FixtureMode, dir: cfg.FixtureDir}
+	llmHTTPClient.Transport = &fixtureTransport{kind: fixtureKindLLM, store: appFixtures, next: llmHTTPClient.Transport}
+	imageHTTPClient.Transport = &fixtureTransport{kind: fixtureKindImage, store: appFixtures, next: imageHTTPClient.Transport}
+	geminiHTTPClient.Transport = &fixtureTransport{kind: fixtureKindImage, store: appFixtures, next: geminiHTTPClient.Transport}
+	return nil
+}
+
+// fixtureKey hashes kind and the normalized request into the fixture's file name.
+func fixtureKey(kind string, request []byte) string {
+	sum := sha256.Sum256(append([]byte(kind+"\n"), request...))
+	return hex.EncodeToString(sum[:16])
+}
+
+func (s *fixtureStore) path(kind, key string) string {
+	return filepath.Join(s.dir, kind, key+".json")
+}
+
+// load returns the fixture recorded for request. A miss is not retryable: replaying the
+// same request again can't find a different file.
+func (s *fixtureStore) load(kind string, request []byte) (fixture, error) {
+	key := fixtureKey(kind, request)
+	data, err := os.ReadFile(s.path(kind, key))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			msg := fmt.Sprintf("no %s fixture for request %s in %s; record one with FIXTURE_MODE=%s: %s", kind, key, s.dir, FixtureRecord, truncateString(string(request), 500))
+			return fixture{}, temporal.NewNonRetryableApplicationError(msg, "FixtureNotFound", nil)
+		}
+		return fixture{}, err
+	}
+	var f fixture
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fixture{}, fmt.Errorf("failed to parse fixture %s: %w", key, err)
+	}
+	return f, nil
+}
+
+// save records response (or callErr) for request, replacing any earlier recording.
+func (s *fixtureStore) save(kind string, request []byte, response any, callErr error) error {
+	f := fixture{Kind: kind, Key: fixtureKey(kind, request), Request: request}
+	if callErr != nil {
+		f.Error = callErr.Error()
+	} else {
+		data, err := json.Marshal(response)
+		if err != nil {
+			return fmt.Errorf("failed to marshal fixture response: %w", err)
+		}
+		f.Response = data
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal fixture: %w", err)
+	}
+	path := s.path(kind, f.Key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create fixture directory: %w", err)
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// ghCommand records or replays a gh invocation. Commands are keyed with their whitespace
+// collapsed. A recorded failure replays as a non-retryable error carrying the same message,
+// which is how ExecuteGhCommandActivity reports a failed command.
+func (s *fixtureStore) ghCommand(ctx context.Context, command string, run func(context.Context, string) (string, error)) (string, error) {
+	request, _ := json.Marshal(map[string]string{"command": strings.Join(strings.Fields(command), " ")})
+	if s.mode == FixtureReplay {
+		f, err := s.load(fixtureKindGh, request)
+		if err != nil {
+			return "", err
+		}
+		if f.Error != "" {
+			return "", temporal.NewNonRetryableApplicationError(f.Error, "GhCommandExecutionError", nil)
+		}
+		var output string
+		if err := json.Unmarshal(f.Response, &output); err != nil {
+			return "", fmt.Errorf("failed to parse gh fixture %s: %w", f.Key, err)
+		}
+		return output, nil
+	}
+
+	output, err := run(ctx, command)
+	// Only failures of the command itself are worth replaying; a cancelled context isn't
+	if err == nil || ctx.Err() == nil {
+		if saveErr := s.save(fixtureKindGh, request, output, err); saveErr != nil {
+			// A recording that can't be saved shouldn't fail the run it was recording
+			log.Printf("fixtures: failed to save gh fixture: %v", saveErr)
+		}
+	}
+	return output, err
+}
+
+// fixtureResponse is a recorded HTTP response. JSON bodies are kept as JSON so recorded
+// conversations can be read and edited; anything else (images) is base64.
+type fixtureResponse struct {
+	Status      int             `json:"status"`
+	ContentType string          `json:"content_type,omitempty"`
+	JSON        json.RawMessage `json:"json,omitempty"`
+	Body        []byte          `json:"body,omitempty"`
+}
+
+// fixtureTransport records or replays HTTP requests. Only the method, path, query and
+// normalized body are keyed, so API keys never reach fixture files and changing hosts or
+// keys doesn't invalidate a recording.
+type fixtureTransport struct {
+	kind  string
+	store *fixtureStore
+	next  http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	request, err := normalizeHTTPRequest(req, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.store.mode == FixtureReplay {
+		f, err := t.store.load(t.kind, request)
+		if err != nil {
+			return nil, err
+		}
+		if f.Error != "" {
+			// Like a miss, a recorded failure can't turn into a success on retry
+			return nil, temporal.NewNonRetryableApplicationError(f.Error, "FixtureTransportError", nil)
+		}
+		var recorded fixtureResponse
+		if err := json.Unmarshal(f.Response, &recorded); err != nil {
+			return nil, fmt.Errorf("failed to parse %s fixture %s: %w", t.kind, f.Key, err)
+		}
+		respBody := recorded.Body
+		if recorded.JSON != nil {
+			respBody = recorded.JSON
+		}
+		header := make(http.Header)
+		if recorded.ContentType != "" {
+			header.Set("Content-Type", recorded.ContentType)
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", recorded.Status, http.StatusText(recorded.Status)),
+			StatusCode:    recorded.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader(respBody)),
+			ContentLength: int64(len(respBody)),
+			Request:       req,
+		}, nil
+	}
+
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	recorded := fixtureResponse{Status: resp.StatusCode, ContentType: resp.Header.Get("Content-Type")}
+	if json.Valid(respBody) {
+		recorded.JSON = respBody
+	} else {
+		recorded.Body = respBody
+	}
+	if err := t.store.save(t.kind, request, recorded, nil); err != nil {
+		log.Printf("fixtures: failed to save %s fixture: %v", t.kind, err)
+	}
+	return resp, nil
+}
+
+// fixtureIgnoredFields are dropped from JSON request bodies before keying: random seeds
+// would make every recorded image request a miss on replay.
+var fixtureIgnoredFields = map[string]bool{"seed": true}
+
+// fixtureIgnoredParams are dropped from request URLs before keying, since they carry
+// credentials.
+var fixtureIgnoredParams = map[string]bool{"key": true, "api_key": true}
+
+// normalizeHTTPRequest returns the part of req that identifies it: method, path, query
+// without credentials, and the body with JSON re-encoded in canonical key order. Multipart
+// bodies are reduced to their field names and content hashes, since boundaries are random.
+func normalizeHTTPRequest(req *http.Request, body []byte) ([]byte, error) {
+	query := req.URL.Query()
+	for param := range fixtureIgnoredParams {
+		query.Del(param)
+	}
+	normalized := map[string]any{
+		"method": req.Method,
+		"path":   req.URL.Path,
+	}
+	if len(query) > 0 {
+		normalized["query"] = query.Encode()
+	}
+
+	mediaType, params, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	switch {
+	case len(body) == 0:
+	case json.Valid(body):
+		var v any
+		if err := json.Unmarshal(body, &v); err != nil {
+			return nil, err
+		}
+		normalized["body"] = dropFixtureFields(v)
+	case strings.HasPrefix(mediaType, "multipart/"):
+		parts, err := multipartDigest(body, params["boundary"])
+		if err != nil {
+			return nil, err
+		}
+		normalized["body"] = parts
+	default:
+		sum := sha256.Sum256(body)
+		normalized["body_sha256"] = hex.EncodeToString(sum[:])
+	}
+	// encoding/json sorts map keys, which makes the encoding canonical
+	return json.Marshal(normalized)
+}
+
+// dropFixtureFields removes fixtureIgnoredFields from every object in v.
+func dropFixtureFields(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			if fixtureIgnoredFields[k] {
+				delete(v, k)
+				continue
+			}
+			v[k] = dropFixtureFields(child)
+		}
+	case []any:
+		for i, child := range v {
+			v[i] = dropFixtureFields(child)
+		}
+	}
+	return v
+}
+
+// multipartDigest lists a multipart body's fields as "name=value" for short text fields
+// and "name:sha256" for everything else, sorted so field order doesn't matter.
+func multipartDigest(body []byte, boundary string) ([]string, error) {
+	r := multipart.NewReader(bytes.NewReader(body), boundary)
+	var parts []string
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read multipart body: %w", err)
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read multipart body: %w", err)
+		}
+		if part.FileName() == "" && len(data) <= 256 {
+			parts = append(parts, part.FormName()+"="+string(data))
+		} else {
+			sum := sha256.Sum256(data)
+			parts = append(parts, part.FormName()+":"+hex.EncodeToString(sum[:]))
+		}
+	}
+	sort.Strings(parts)
+	return parts, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.temporal.io/sdk/testsuite"
+)
+
+// fakeConfig points the research agent and the image model at the fake services.
+func fakeConfig(mode, dir, llmURL, sdURL string) *Config {
+	return &Config{
+		StorageBucket:                testBucket,
+		GeminiModel:                  "sd:",
+		SDBaseURL:                    sdURL,
+		ResearchOrchestratorProvider: LLMProviderChat,
+		ResearchOrchestratorAPIKey:   "test-key",
+		ResearchOrchestratorModel:    "test-model",
+		ResearchOrchestratorBaseURL:  llmURL,
+		ResearchAgentPrompt:          "You research GitHub profiles.",
+		ContentGenerationPrompt:      "Draw a meme about this developer.",
+		ImageFormat:                  "png",
+		ImageWidth:                   256,
+		ImageHeight:                  256,
+		FixtureMode:                  mode,
+		FixtureDir:                   dir,
+	}
+}
+
+// TestFixtureTransportRoundTrip records a content generation session against a fake LLM,
+// image model and gh, then replays the recording with the services shut down and a gh
+// that always fails. Both runs must produce the same result.
+func TestFixtureTransportRoundTrip(t *testing.T) {
+	fixtureDir := t.TempDir()
+	llm := httptest.NewServer(http.HandlerFunc(fakeChatCompletions))
+	sd := httptest.NewServer(http.HandlerFunc(fakeTxt2Img))
+
+	run := func(t *testing.T, env *testsuite.TestWorkflowEnvironment) any {
+		env.ExecuteWorkflow(RunContentGenerationWorkflow, contentGenerationInput("octocat"))
+		var output AppOutput
+		workflowResult(t, env, &output)
+
+		if output.GitHubProfile.Username != "octocat" || output.GitHubProfile.Bio != "octocat builds things in Go" {
+			t.Errorf("profile = %+v, want octocat's profile from gh", output.GitHubProfile)
+		}
+		if calls := output.Usage[appConfig.ResearchOrchestratorModel].Calls; calls != 2 {
+			t.Errorf("usage counts %d LLM calls, want 2", calls)
+		}
+		if _, err := appStorage.StatInfo(context.Background(), testBucket, output.StorageKey); err != nil {
+			t.Errorf("generated image %s was not stored: %v", output.StorageKey, err)
+		}
+		return comparableOutput(output)
+	}
+
+	var recorded any
+	t.Run("record", func(t *testing.T) {
+		useFakeGh(t, fakeGhScript)
+		useConfig(t, fakeConfig(FixtureRecord, fixtureDir, llm.URL, sd.URL))
+		recorded = run(t, newTestEnv())
+	})
+	llm.Close()
+	sd.Close()
+	if t.Failed() {
+		return
+	}
+	for _, kind := range []string{fixtureKindLLM, fixtureKindImage, fixtureKindGh} {
+		if files, _ := os.ReadDir(filepath.Join(fixtureDir, kind)); len(files) == 0 {
+			t.Errorf("recording has no %s fixtures", kind)
+		}
+	}
+
+	t.Run("replay", func(t *testing.T) {
+		useFakeGh(t, ghMustNotRun)
+		useConfig(t, fakeConfig(FixtureReplay, fixtureDir, llm.URL, sd.URL))
+		if replayed := run(t, newTestEnv()); !reflect.DeepEqual(replayed, recorded) {
+			t.Errorf("replay produced\n%+v\nbut the recorded run produced\n%+v", replayed, recorded)
+		}
+	})
+}
+
+// fakeGhScript answers "gh api users/<login>" like the GitHub API would.
+const fakeGhScript = `#!/bin/sh
+login=${2#users/}
+printf '{"login": "%s", "bio": "%s builds things in Go", "public_repos": 3}\n' "$login" "$login"
+`
+
+// fakeChatCompletions plays a research agent: it looks the user up with gh, then submits
+// a profile built from the gh output.
+func fakeChatCompletions(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Messages []struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Messages) == 0 {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	_, username, _ := strings.Cut(req.Messages[0].Content, testPromptIntro)
+	username = strings.TrimSpace(username)
+
+	call := map[string]any{"id": "call_gh", "type": "function", "function": map[string]any{
+		"name":      "gh",
+		"arguments": `{"command": "api users/` + username + `"}`,
+	}}
+	if last := req.Messages[len(req.Messages)-1]; last.Role == RoleTool {
+		var user struct {
+			Login       string `json:"login"`
+			Bio         string `json:"bio"`
+			PublicRepos int    `json:"public_repos"`
+		}
+		json.Unmarshal([]byte(last.Content), &user)
+		profile, _ := json.Marshal(GitHubProfile{
+			Username:      user.Login,
+			Bio:           user.Bio,
+			PublicRepos:   user.PublicRepos,
+			OriginalRepos: user.PublicRepos,
+			Languages:     []string{"Go"},
+		})
+		call = map[string]any{"id": "call_submit", "type": "function", "function": map[string]any{
+			"name":      "submit_github_profile",
+			"arguments": string(profile),
+		}}
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"id":      "chatcmpl-test",
+		"choices": []any{map[string]any{"message": map[string]any{"content": "", "tool_calls": []any{call}}}},
+		"usage":   map[string]any{"prompt_tokens": 100 * len(req.Messages), "completion_tokens": 20},
+	})
+}
+
+// fakeTxt2Img serves the Stable Diffusion txt2img API with fakeImageGenerator's images.
+func fakeTxt2Img(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Prompt string `json:"prompt"`
+		Width  int    `json:"width"`
+		Height int    `json:"height"`
+	}
+	if r.URL.Path != "/sdapi/v1/txt2img" || json.NewDecoder(r.Body).Decode(&req) != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	data, err := fakeImageGenerator{}.Generate(r.Context(), ImageRequest{Prompt: req.Prompt, Width: req.Width, Height: req.Height})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"images": []string{base64.StdEncoding.EncodeToString(data)}})
+}
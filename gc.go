@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+)
+
+// pollWorkflowIDPrefix is the prefix handleCreatePoll gives every poll workflow ID,
+// which is also the poll's top-level folder name in storage.
+const pollWorkflowIDPrefix = "g2i-poll-"
+
+// reservedFolders are top-level folders that hold application data rather than a
+// user's generations. GC never trims them as user folders; a feature that adds a
+// top-level folder must list it here.
+var reservedFolders = map[string]bool{
+	pollArchiveFolder: true, // archived polls are kept until removed by hand
+	usageFolder:       true, // usage records feed the cost report and are tiny
+	blobFolder:        true, // swept separately, once references have been trimmed
+}
+
+// GCOptions controls which objects the storage garbage collector removes.
+type GCOptions struct {
+	KeepImagesPerUser int           // newest username/<timestamp>/ generations to keep per user
+	PollRetention     time.Duration // how long after a poll closes its folder is kept
+	DryRun            bool          // if true, only report what would be deleted
+}
+
+// GCResult summarizes a garbage collection run.
+type GCResult struct {
+	Deleted []string // prefixes deleted (or that would be, in dry-run mode)
+	Kept    int      // top-level folders left untouched
+}
+
+// RunStorageGC deletes poll folders whose workflow closed more than PollRetention ago
+// (or no longer exists), trims each user's folder to their newest generations, and
+// then deletes blobs that are no longer referenced.
+func RunStorageGC(ctx context.Context, c client.Client, storage ObjectStorage, bucket string, opts GCOptions) (GCResult, error) {
+	var result GCResult
+
+	folders, err := storage.ListTopLevelFolders(ctx, bucket)
+	if err != nil {
+		return result, fmt.Errorf("failed to list top-level folders: %w", err)
+	}
+	sort.Strings(folders)
+
+	for _, folder := range folders {
+		if folder == blobFolder {
+			// Blobs are swept below, once references have been trimmed
+			continue
+		}
+		if reservedFolders[folder] {
+			result.Kept++
+			continue
+		}
+
+		var prefixes []string
+		if strings.HasPrefix(folder, pollWorkflowIDPrefix) {
+			expired, reason, err := pollFolderExpired(ctx, c, folder, opts.PollRetention)
+			if err != nil {
+				log.Printf("gc: skipping poll folder %s: %v", folder, err)
+				result.Kept++
+				continue
+			}
+			if !expired {
+				result.Kept++
+				continue
+			}
+			log.Printf("gc: poll folder %s is eligible for deletion (%s)", folder, reason)
+			prefixes = []string{folder + "/"}
+		} else if !githubUsernameRe.MatchString(folder) {
+			log.Printf("gc: skipping unrecognized folder %s", folder)
+			result.Kept++
+			continue
+		} else {
+			prefixes, err = staleUserGenerations(ctx, storage, bucket, folder, opts.KeepImagesPerUser)
+			if err != nil {
+				log.Printf("gc: skipping user folder %s: %v", folder, err)
+				result.Kept++
+				continue
+			}
+			if len(prefixes) == 0 {
+				result.Kept++
+				continue
+			}
+		}
+
+		for _, prefix := range prefixes {
+			if opts.DryRun {
+				log.Printf("gc: [dry-run] would delete %s", prefix)
+			} else {
+				if err := storage.Delete(ctx, bucket, prefix); err != nil {
+					return result, fmt.Errorf("failed to delete %s: %w", prefix, err)
+				}
+				log.Printf("gc: deleted %s", prefix)
+			}
+			result.Deleted = append(result.Deleted, prefix)
+		}
+	}
+
+	orphans, err := orphanedBlobs(ctx, storage, bucket)
+	if err != nil {
+		return result, fmt.Errorf("failed to find orphaned blobs: %w", err)
+	}
+	for _, key := range orphans {
+		if opts.DryRun {
+			log.Printf("gc: [dry-run] would delete unreferenced blob %s", key)
+		} else {
+			if err := storage.DeleteObject(ctx, bucket, key); err != nil {
+				return result, fmt.Errorf("failed to delete %s: %w", key, err)
+			}
+			log.Printf("gc: deleted unreferenced blob %s", key)
+		}
+		result.Deleted = append(result.Deleted, key)
+	}
+
+	return result, nil
+}
+
+// orphanedBlobs returns blob keys no reference record points at. Blobs younger than
+// blobGracePeriod are skipped since their reference may not have been written yet.
+// In dry-run mode references that would have been deleted still count, so this
+// undercounts what a real run removes.
+func orphanedBlobs(ctx context.Context, storage ObjectStorage, bucket string) ([]string, error) {
+	keys, err := storage.List(ctx, bucket, "")
+	if err != nil {
+		return nil, err
+	}
+
+	referenced := make(map[string]struct{})
+	var blobs []string
+	for _, key := range keys {
+		if strings.HasPrefix(key, blobPrefix) {
+			blobs = append(blobs, key)
+			continue
+		}
+		if !strings.HasSuffix(key, refSuffix) {
+			continue
+		}
+		ref, err := readImageRef(ctx, storage, bucket, key)
+		if err != nil {
+			// An unreadable reference might still protect a blob; don't guess
+			return nil, err
+		}
+		for _, blob := range ref.blobKeys() {
+			referenced[blob] = struct{}{}
+		}
+	}
+
+	var orphans []string
+	for _, key := range blobs {
+		if _, ok := referenced[key]; ok {
+			continue
+		}
+		info, err := storage.StatInfo(ctx, bucket, key)
+		if err != nil {
+			return nil, err
+		}
+		if time.Since(info.LastModified) < blobGracePeriod {
+			continue
+		}
+		orphans = append(orphans, key)
+	}
+	return orphans, nil
+}
+
+// pollFolderExpired reports whether a poll's folder can be deleted: its workflow is
+// gone entirely, or it closed more than retention ago.
+func pollFolderExpired(ctx context.Context, c client.Client, pollID string, retention time.Duration) (bool, string, error) {
+	desc, err := c.DescribeWorkflowExecution(ctx, pollID, "")
+	if err != nil {
+		var notFoundErr *serviceerror.NotFound
+		if errors.As(err, &notFoundErr) {
+			return true, "workflow not found", nil
+		}
+		return false, "", fmt.Errorf("failed to describe workflow: %w", err)
+	}
+
+	info := desc.WorkflowExecutionInfo
+	if info.Status == enums.WORKFLOW_EXECUTION_STATUS_RUNNING {
+		return false, "", nil
+	}
+	closedAt := info.CloseTime.AsTime()
+	if time.Since(closedAt) < retention {
+		return false, "", nil
+	}
+	return true, fmt.Sprintf("%s at %s", info.Status, closedAt.Format(time.RFC3339)), nil
+}
+
+// staleUserGenerations returns the username/<timestamp>/ prefixes beyond the newest keep.
+func staleUserGenerations(ctx context.Context, storage ObjectStorage, bucket, username string, keep int) ([]string, error) {
+	keys, err := storage.List(ctx, bucket, username+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	// Keys look like username/<unix timestamp>/content.ext
+	seen := make(map[string]struct{})
+	var generations []string
+	for _, key := range keys {
+		parts := strings.Split(strings.TrimPrefix(key, username+"/"), "/")
+		if len(parts) < 2 {
+			continue
+		}
+		if _, ok := seen[parts[0]]; !ok {
+			seen[parts[0]] = struct{}{}
+			generations = append(generations, parts[0])
+		}
+	}
+	if len(generations) <= keep {
+		return nil, nil
+	}
+
+	// Timestamps are unix seconds, so compare numerically (longer is newer)
+	sort.Slice(generations, func(i, j int) bool {
+		if len(generations[i]) != len(generations[j]) {
+			return len(generations[i]) > len(generations[j])
+		}
+		return generations[i] > generations[j]
+	})
+
+	var prefixes []string
+	for _, generation := range generations[keep:] {
+		prefixes = append(prefixes, username+"/"+generation+"/")
+	}
+	return prefixes, nil
+}
@@ -0,0 +1,535 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math/rand"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nfnt/resize"
+	"golang.org/x/image/font"
+	"google.golang.org/genai"
+)
+
+// ImageRequest describes the image to generate.
+type ImageRequest struct {
+	Prompt string
+	// Model is the model name with any provider prefix removed.
+	Model string
+	// Width and Height are hints; providers round them to sizes they support or ignore them.
+	Width  int
+	Height int
+	// Reference optionally conditions the image on another image, e.g. the developer's avatar.
+	// Only generators implementing referenceImageGenerator use it.
+	Reference *ReferenceImage
+}
+
+// ReferenceImage is an input image for image-to-image generation.
+type ReferenceImage struct {
+	Data        []byte
+	ContentType string
+}
+
+// ImageGenerator produces an image from a prompt and returns it in whatever format the
+// provider emits.
+type ImageGenerator interface {
+	Generate(ctx context.Context, req ImageRequest) ([]byte, error)
+}
+
+// referenceImageGenerator is implemented by generators that can condition on
+// ImageRequest.Reference. Others generate from the prompt alone.
+type referenceImageGenerator interface {
+	AcceptsReference(model string) bool
+}
+
+// acceptsReference reports whether the generator for modelName uses reference images.
+func acceptsReference(cfg *Config, modelName string) bool {
+	generator, model := NewImageGenerator(cfg, modelName)
+	r, ok := generator.(referenceImageGenerator)
+	return ok && r.AcceptsReference(model)
+}
+
+// imageProvider routes model names starting with prefix to a generator. If strip is set
+// the prefix is removed before the model name is passed on.
+type imageProvider struct {
+	prefix string
+	strip  bool
+	new    func(cfg *Config) ImageGenerator
+}
+
+// imageProviders is checked in order; the first matching prefix wins and Gemini is the
+// fallback, so existing GEMINI_MODEL values keep working.
+var imageProviders = []imageProvider{
+	{prefix: "fake:", strip: true, new: func(*Config) ImageGenerator { return fakeImageGenerator{} }},
+	{prefix: "sd:", strip: true, new: func(cfg *Config) ImageGenerator { return &automatic1111Generator{baseURL: cfg.SDBaseURL} }},
+	{prefix: "comfyui:", strip: true, new: func(cfg *Config) ImageGenerator { return &comfyUIGenerator{baseURL: cfg.ComfyUIBaseURL} }},
+	{prefix: "dall-e-", new: newOpenAIImageGenerator},
+	{prefix: "gpt-image-", new: newOpenAIImageGenerator},
+	{prefix: "openai:", strip: true, new: newOpenAIImageGenerator},
+	{prefix: "", new: func(cfg *Config) ImageGenerator { return geminiImageGenerator{apiKey: cfg.GoogleAPIKey} }},
+}
+
+// NewImageGenerator returns the generator for modelName, e.g. "gemini-2.5-flash-image",
+// "dall-e-3", "gpt-image-1", "sd:<checkpoint>", "comfyui:<checkpoint>" or "fake:", along
+// with the model name to pass to it.
+func NewImageGenerator(cfg *Config, modelName string) (ImageGenerator, string) {
+	for _, p := range imageProviders {
+		if !strings.HasPrefix(modelName, p.prefix) {
+			continue
+		}
+		if p.strip {
+			modelName = strings.TrimPrefix(modelName, p.prefix)
+		}
+		return p.new(cfg), modelName
+	}
+	// Unreachable: the last provider matches every name
+	return geminiImageGenerator{apiKey: cfg.GoogleAPIKey}, modelName
+}
+
+// imageHTTPClient is shared by the HTTP-based generators. Local Stable Diffusion can take
+// minutes per image, so the timeout is generous; the activity context bounds it anyway.
+var imageHTTPClient = &http.Client{Timeout: 5 * time.Minute}
+
+// geminiHTTPClient is passed to every genai client so fixtures can intercept Gemini calls.
+var geminiHTTPClient = &http.Client{}
+
+// postJSON sends body as JSON to url and decodes the JSON response into out.
+func postJSON(ctx context.Context, url string, headers map[string]string, body, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return doJSON(req, out)
+}
+
+// doJSON sends req and decodes its JSON response into out.
+func doJSON(req *http.Request, out any) error {
+	resp, err := imageHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d: %s", req.URL.Host, resp.StatusCode, truncateString(string(body), 500))
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// postMultipart sends fields and files as multipart/form-data to url and decodes the JSON
+// response into out.
+func postMultipart(ctx context.Context, url string, headers map[string]string, fields map[string]string, files map[string]*ReferenceImage, out any) error {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for name, value := range fields {
+		if err := mw.WriteField(name, value); err != nil {
+			return fmt.Errorf("failed to write field %s: %w", name, err)
+		}
+	}
+	for name, file := range files {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename="%s.%s"`, name, name, extensionForContentType(file.ContentType)))
+		h.Set("Content-Type", file.ContentType)
+		part, err := mw.CreatePart(h)
+		if err != nil {
+			return fmt.Errorf("failed to create part %s: %w", name, err)
+		}
+		if _, err := part.Write(file.Data); err != nil {
+			return fmt.Errorf("failed to write part %s: %w", name, err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return fmt.Errorf("failed to finish multipart body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, &buf)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return doJSON(req, out)
+}
+
+// getBytes fetches url and returns the response body.
+func getBytes(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := imageHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s returned status %d", url, resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// geminiImageGenerator uses a Gemini image model through the genai SDK.
+type geminiImageGenerator struct {
+	apiKey string
+}
+
+// Generate implements ImageGenerator.
+func (g geminiImageGenerator) Generate(ctx context.Context, req ImageRequest) ([]byte, error) {
+	if g.apiKey == "" {
+		return nil, fmt.Errorf("GOOGLE_API_KEY not configured")
+	}
+
+	// Initialize Gemini client. It will use the GOOGLE_API_KEY environment variable if it is set.
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{HTTPClient: geminiHTTPClient})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create genai client: %w", err)
+	}
+
+	contents := genai.Text(req.Prompt)
+	if req.Reference != nil {
+		contents = []*genai.Content{genai.NewContentFromParts([]*genai.Part{
+			genai.NewPartFromText(req.Prompt),
+			genai.NewPartFromBytes(req.Reference.Data, req.Reference.ContentType),
+		}, genai.RoleUser)}
+	}
+	result, err := client.Models.GenerateContent(ctx, req.Model, contents, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate content: %w", err)
+	}
+	recordUsage(ctx, req.Model, geminiUsage(result.UsageMetadata))
+
+	if len(result.Candidates) == 0 || result.Candidates[0].Content == nil || len(result.Candidates[0].Content.Parts) == 0 {
+		return nil, fmt.Errorf("no content returned from API")
+	}
+	for _, part := range result.Candidates[0].Content.Parts {
+		if part.InlineData != nil {
+			return part.InlineData.Data, nil
+		}
+	}
+	return nil, errNoImageData
+}
+
+// geminiUsage converts Gemini's token counts. Thinking tokens are billed as output.
+func geminiUsage(m *genai.GenerateContentResponseUsageMetadata) TokenUsage {
+	if m == nil {
+		return TokenUsage{}
+	}
+	return TokenUsage{
+		InputTokens:  int(m.PromptTokenCount),
+		OutputTokens: int(m.CandidatesTokenCount + m.ThoughtsTokenCount),
+		CachedTokens: int(m.CachedContentTokenCount),
+	}
+}
+
+// AcceptsReference implements referenceImageGenerator. Gemini image models take images as input.
+func (geminiImageGenerator) AcceptsReference(string) bool { return true }
+
+// openAIImageGenerator uses the OpenAI Images API (DALL-E and gpt-image models).
+type openAIImageGenerator struct {
+	apiKey  string
+	baseURL string
+}
+
+func newOpenAIImageGenerator(cfg *Config) ImageGenerator {
+	return &openAIImageGenerator{apiKey: cfg.OpenAIAPIKey, baseURL: strings.TrimSuffix(cfg.OpenAIBaseURL, "/")}
+}
+
+// Generate implements ImageGenerator.
+func (g *openAIImageGenerator) Generate(ctx context.Context, req ImageRequest) ([]byte, error) {
+	if g.apiKey == "" {
+		return nil, fmt.Errorf("OPENAI_API_KEY not configured")
+	}
+
+	body := map[string]any{
+		"model":  req.Model,
+		"prompt": req.Prompt,
+		"n":      1,
+		"size":   openAIImageSize(req.Model, req.Width, req.Height),
+	}
+	// gpt-image models always return base64 and reject response_format
+	if strings.HasPrefix(req.Model, "dall-e-") {
+		body["response_format"] = "b64_json"
+	}
+
+	var resp struct {
+		Data []struct {
+			B64JSON string `json:"b64_json"`
+			URL     string `json:"url"`
+		} `json:"data"`
+		// Only gpt-image models report usage; DALL-E is billed per image
+		Usage struct {
+			InputTokens  int `json:"input_tokens"`
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+	}
+	headers := map[string]string{"Authorization": "Bearer " + g.apiKey}
+	if req.Reference != nil && g.AcceptsReference(req.Model) {
+		fields := map[string]string{"model": req.Model, "prompt": req.Prompt, "size": body["size"].(string)}
+		files := map[string]*ReferenceImage{"image": req.Reference}
+		if err := postMultipart(ctx, g.baseURL+"/v1/images/edits", headers, fields, files, &resp); err != nil {
+			return nil, fmt.Errorf("openai image edit failed: %w", err)
+		}
+	} else if err := postJSON(ctx, g.baseURL+"/v1/images/generations", headers, body, &resp); err != nil {
+		return nil, fmt.Errorf("openai image generation failed: %w", err)
+	}
+	recordUsage(ctx, req.Model, TokenUsage{InputTokens: resp.Usage.InputTokens, OutputTokens: resp.Usage.OutputTokens})
+	if len(resp.Data) == 0 {
+		return nil, errNoImageData
+	}
+	if resp.Data[0].B64JSON != "" {
+		return base64.StdEncoding.DecodeString(resp.Data[0].B64JSON)
+	}
+	if resp.Data[0].URL != "" {
+		return getBytes(ctx, resp.Data[0].URL)
+	}
+	return nil, errNoImageData
+}
+
+// AcceptsReference implements referenceImageGenerator. Only gpt-image models can edit
+// arbitrary input images; DALL-E 2 edits need a mask and DALL-E 3 has no edit endpoint.
+func (g *openAIImageGenerator) AcceptsReference(model string) bool {
+	return strings.HasPrefix(model, "gpt-image-")
+}
+
+// openAIImageSize picks the supported size closest in aspect ratio to width x height.
+func openAIImageSize(model string, width, height int) string {
+	landscape, portrait := "1536x1024", "1024x1536"
+	if strings.HasPrefix(model, "dall-e-3") {
+		landscape, portrait = "1792x1024", "1024x1792"
+	}
+	switch {
+	case strings.HasPrefix(model, "dall-e-2") || width <= 0 || height <= 0:
+		return "1024x1024"
+	case width*4 >= height*5:
+		return landscape
+	case height*4 >= width*5:
+		return portrait
+	default:
+		return "1024x1024"
+	}
+}
+
+// automatic1111Generator uses the txt2img API of a Stable Diffusion web UI (AUTOMATIC1111
+// or Forge) started with --api. The model name, if any, selects the checkpoint.
+type automatic1111Generator struct {
+	baseURL string
+}
+
+// Generate implements ImageGenerator.
+func (g *automatic1111Generator) Generate(ctx context.Context, req ImageRequest) ([]byte, error) {
+	width, height := sdImageSize(req.Width, req.Height)
+	body := map[string]any{
+		"prompt": req.Prompt,
+		"width":  width,
+		"height": height,
+		"steps":  30,
+	}
+	if req.Model != "" {
+		body["override_settings"] = map[string]any{"sd_model_checkpoint": req.Model}
+	}
+	endpoint := "txt2img"
+	if req.Reference != nil {
+		// Keep enough of the avatar to be recognizable while leaving room for the meme
+		endpoint = "img2img"
+		body["init_images"] = []string{base64.StdEncoding.EncodeToString(req.Reference.Data)}
+		body["denoising_strength"] = 0.75
+		body["resize_mode"] = 2 // resize and fill
+	}
+
+	var resp struct {
+		Images []string `json:"images"`
+	}
+	if err := postJSON(ctx, strings.TrimSuffix(g.baseURL, "/")+"/sdapi/v1/"+endpoint, nil, body, &resp); err != nil {
+		return nil, fmt.Errorf("stable diffusion %s failed: %w", endpoint, err)
+	}
+	if len(resp.Images) == 0 {
+		return nil, errNoImageData
+	}
+	return base64.StdEncoding.DecodeString(resp.Images[0])
+}
+
+// AcceptsReference implements referenceImageGenerator using the img2img endpoint.
+func (g *automatic1111Generator) AcceptsReference(string) bool { return true }
+
+// comfyUIGenerator queues a basic text-to-image graph on a ComfyUI server and downloads
+// the result. The model name is the checkpoint file to load.
+type comfyUIGenerator struct {
+	baseURL string
+}
+
+// comfyUIPollInterval is how often the ComfyUI history is checked for a finished prompt.
+const comfyUIPollInterval = 2 * time.Second
+
+// Generate implements ImageGenerator.
+func (g *comfyUIGenerator) Generate(ctx context.Context, req ImageRequest) ([]byte, error) {
+	if req.Model == "" {
+		return nil, fmt.Errorf("comfyui needs a checkpoint, e.g. comfyui:sd_xl_base_1.0.safetensors")
+	}
+	base := strings.TrimSuffix(g.baseURL, "/")
+	width, height := sdImageSize(req.Width, req.Height)
+
+	var queued struct {
+		PromptID string `json:"prompt_id"`
+	}
+	if err := postJSON(ctx, base+"/prompt", nil, map[string]any{"prompt": comfyUIGraph(req, width, height)}, &queued); err != nil {
+		return nil, fmt.Errorf("comfyui prompt failed: %w", err)
+	}
+
+	ticker := time.NewTicker(comfyUIPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/history/"+url.PathEscape(queued.PromptID), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		var history map[string]struct {
+			Outputs map[string]struct {
+				Images []struct {
+					Filename  string `json:"filename"`
+					Subfolder string `json:"subfolder"`
+					Type      string `json:"type"`
+				} `json:"images"`
+			} `json:"outputs"`
+		}
+		if err := doJSON(httpReq, &history); err != nil {
+			return nil, fmt.Errorf("comfyui history failed: %w", err)
+		}
+		entry, ok := history[queued.PromptID]
+		if !ok {
+			continue // still running
+		}
+		for _, output := range entry.Outputs {
+			for _, img := range output.Images {
+				q := url.Values{"filename": {img.Filename}, "subfolder": {img.Subfolder}, "type": {img.Type}}
+				return getBytes(ctx, base+"/view?"+q.Encode())
+			}
+		}
+		return nil, errNoImageData
+	}
+}
+
+// comfyUIGraph builds the API-format workflow for a plain checkpoint text-to-image run.
+func comfyUIGraph(req ImageRequest, width, height int) map[string]any {
+	return map[string]any{
+		"1": map[string]any{"class_type": "CheckpointLoaderSimple", "inputs": map[string]any{"ckpt_name": req.Model}},
+		"2": map[string]any{"class_type": "CLIPTextEncode", "inputs": map[string]any{"text": req.Prompt, "clip": []any{"1", 1}}},
+		"3": map[string]any{"class_type": "CLIPTextEncode", "inputs": map[string]any{"text": "blurry, garbled text, watermark", "clip": []any{"1", 1}}},
+		"4": map[string]any{"class_type": "EmptyLatentImage", "inputs": map[string]any{"width": width, "height": height, "batch_size": 1}},
+		"5": map[string]any{"class_type": "KSampler", "inputs": map[string]any{
+			"model": []any{"1", 0}, "positive": []any{"2", 0}, "negative": []any{"3", 0}, "latent_image": []any{"4", 0},
+			"seed": rand.Int63n(1 << 48), "steps": 30, "cfg": 7, "sampler_name": "euler", "scheduler": "normal", "denoise": 1,
+		}},
+		"6": map[string]any{"class_type": "VAEDecode", "inputs": map[string]any{"samples": []any{"5", 0}, "vae": []any{"1", 2}}},
+		"7": map[string]any{"class_type": "SaveImage", "inputs": map[string]any{"images": []any{"6", 0}, "filename_prefix": "g2i"}},
+	}
+}
+
+// sdImageSize rounds the requested size to multiples of 64, which Stable Diffusion needs,
+// defaulting to 512x512.
+func sdImageSize(width, height int) (int, int) {
+	round := func(v int) int {
+		if v <= 0 {
+			return 512
+		}
+		return max(64, (v+32)/64*64)
+	}
+	return round(width), round(height)
+}
+
+// fakeImageGenerator renders the prompt onto a canvas whose color is derived from the
+// prompt, so the same prompt always yields the same image. It needs no network and is
+// meant for offline tests and demo mode (GEMINI_MODEL=fake:).
+type fakeImageGenerator struct{}
+
+// Generate implements ImageGenerator.
+func (fakeImageGenerator) Generate(_ context.Context, req ImageRequest) ([]byte, error) {
+	width, height := req.Width, req.Height
+	if width <= 0 || height <= 0 {
+		width, height = 512, 512
+	}
+
+	sum := sha256.Sum256([]byte(req.Prompt))
+	bg := color.RGBA{sum[0]/2 + 64, sum[1]/2 + 64, sum[2]/2 + 64, 0xff}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+
+	face, err := collageFace(float64(max(width/24, 12)))
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	// Show the start of the prompt, which is where the developer's details are
+	lineHeight := face.Metrics().Height.Ceil() + 4
+	lines := wrapText(face, strings.Join(strings.Fields(req.Prompt), " "), width*9/10)
+	lines = lines[:min(len(lines), (height*9/10)/lineHeight)]
+	top := (height - len(lines)*lineHeight + lineHeight) / 2
+	for i, line := range lines {
+		drawCentered(dst, face, color.Black, line, width/2, top+i*lineHeight)
+	}
+
+	// Paste the reference into the top-left corner so tests can see it was passed along
+	if req.Reference != nil {
+		if ref, _, err := image.Decode(bytes.NewReader(req.Reference.Data)); err == nil {
+			edge := min(width, height) / 4
+			thumb := resize.Thumbnail(uint(edge), uint(edge), ref, resize.Bilinear)
+			draw.Draw(dst, thumb.Bounds().Sub(thumb.Bounds().Min).Add(image.Pt(8, 8)), thumb, thumb.Bounds().Min, draw.Over)
+		}
+	}
+
+	data, _, err := encodeImage(dst, "png")
+	return data, err
+}
+
+// AcceptsReference implements referenceImageGenerator.
+func (fakeImageGenerator) AcceptsReference(string) bool { return true }
+
+// wrapText breaks text into lines no wider than width.
+func wrapText(face font.Face, text string, width int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		candidate := strings.TrimSpace(line + " " + word)
+		if line != "" && font.MeasureString(face, candidate).Ceil() > width {
+			lines = append(lines, line)
+			candidate = word
+		}
+		line = candidate
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
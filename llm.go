@@ -1,98 +1,40 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
 	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
-func generateResponsesTurn(ctx context.Context, p OpenAIConfig, previousResponseID string, userInput string, tools []Tool, functionOutputs map[string]string, toolChoice any) (string, []ToolCall, string, error) {
-	if p.MaxTokens == 0 {
-		p.MaxTokens = 4096
-	}
-
-	req := map[string]interface{}{
-		"model":             p.Model,
-		"store":             true,
-		"max_output_tokens": p.MaxTokens,
-	}
-
-	if previousResponseID != "" {
-		req["previous_response_id"] = previousResponseID
-		inputs := make([]map[string]interface{}, 0, len(functionOutputs))
-		for callID, output := range functionOutputs {
-			inputs = append(inputs, map[string]interface{}{
-				"type":    "function_call_output",
-				"call_id": callID,
-				"output":  output,
-			})
-		}
-		req["input"] = inputs
-	} else {
-		req["input"] = userInput
-	}
-
-	if len(tools) > 0 {
-		toolList := make([]map[string]interface{}, 0, len(tools))
-		for _, t := range tools {
-			toolList = append(toolList, map[string]interface{}{
-				"type":        "function",
-				"name":        t.Name,
-				"description": t.Description,
-				"parameters":  t.Parameters,
-				"strict":      true,
-			})
-		}
-		req["tools"] = toolList
-		if toolChoice != nil {
-			req["tool_choice"] = toolChoice
-		}
-	}
-
-	jsonData, err := json.Marshal(req)
-	if err != nil {
-		return "", nil, "", fmt.Errorf("failed to marshal responses request: %w", err)
-	}
-	apiURL := p.APIHost + "/v1/responses"
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", nil, "", fmt.Errorf("failed to create responses request: %w", err)
-	}
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+p.APIKey)
-
-	client := &http.Client{}
-	httpResp, err := client.Do(httpReq)
-	if err != nil {
-		return "", nil, "", fmt.Errorf("failed to send responses request: %w", err)
-	}
-	defer httpResp.Body.Close()
-	body, _ := io.ReadAll(httpResp.Body)
-	if httpResp.StatusCode != http.StatusOK {
-		return "", nil, "", fmt.Errorf("responses api returned status %d: %s", httpResp.StatusCode, string(body))
-	}
-
-	assistantText, toolCalls, responseID, err := parseResponsesOutput(body)
-	if err != nil {
-		return "", nil, "", err
-	}
-	return assistantText, toolCalls, responseID, nil
-}
-
-func parseResponsesOutput(body []byte) (assistantText string, toolCalls []ToolCall, responseID string, err error) {
+// parseResponsesOutput extracts the assistant text, tool calls, response ID and token usage
+// from a Responses API body.
+func parseResponsesOutput(body []byte) (LLMResponse, error) {
 	var root struct {
 		ID     string          `json:"id"`
 		Output json.RawMessage `json:"output"`
+		Usage  struct {
+			InputTokens        int `json:"input_tokens"`
+			OutputTokens       int `json:"output_tokens"`
+			InputTokensDetails struct {
+				CachedTokens int `json:"cached_tokens"`
+			} `json:"input_tokens_details"`
+		} `json:"usage"`
 	}
 	if e := json.Unmarshal(body, &root); e != nil {
-		return "", nil, "", fmt.Errorf("failed to decode responses body: %w", e)
+		return LLMResponse{}, fmt.Errorf("failed to decode responses body: %w", e)
+	}
+	responseID := root.ID
+	usage := TokenUsage{
+		Calls:        1,
+		InputTokens:  root.Usage.InputTokens,
+		OutputTokens: root.Usage.OutputTokens,
+		CachedTokens: root.Usage.InputTokensDetails.CachedTokens,
 	}
-	responseID = root.ID
 
 	var items []map[string]any
 	if e := json.Unmarshal(root.Output, &items); e != nil {
@@ -107,7 +49,7 @@ func parseResponsesOutput(body []byte) (assistantText string, toolCalls []ToolCa
 			if e3 := json.Unmarshal(root.Output, &singleItem); e3 == nil {
 				items = []map[string]any{singleItem}
 			} else {
-				return "", nil, responseID, fmt.Errorf("unexpected responses output format: %v", e)
+				return LLMResponse{ID: responseID, Usage: usage}, fmt.Errorf("unexpected responses output format: %v", e)
 			}
 		}
 	}
@@ -160,70 +102,164 @@ func parseResponsesOutput(body []byte) (assistantText string, toolCalls []ToolCa
 		}
 	}
 
-	return strings.TrimSpace(strings.Join(textBuilder, "\n")), calls, responseID, nil
+	return LLMResponse{
+		Assistant: strings.TrimSpace(strings.Join(textBuilder, "\n")),
+		Calls:     calls,
+		ID:        responseID,
+		Usage:     usage,
+	}, nil
 }
 
-func structToJSONSchema(s any) (map[string]any, error) {
-	val := reflect.ValueOf(s)
-	typ := val.Type()
-
-	if typ.Kind() == reflect.Ptr {
+// structToJSONSchema derives the JSON Schema of a struct from its json tags, for use as tool
+// parameters. Nested structs, slices, maps and time.Time are supported. Schemas follow
+// OpenAI's strict mode: every property is required and objects forbid extra properties,
+// so optional fields (pointers, omitempty and the jsonschema "nullable" option) accept null
+// instead of being left out. Fields may carry a jsonschema tag such as
+// `jsonschema:"description=Stars on GitHub,enum=public|private,nullable"`, or "-" to hide them.
+// strict reports whether the schema is valid in strict mode, which rules out maps.
+func structToJSONSchema(s any) (schema map[string]any, strict bool, err error) {
+
+	typ := reflect.TypeOf(s)
+	if typ == nil {
+		return nil, false, fmt.Errorf("expected a struct, got nil")
+	}
+	for typ.Kind() == reflect.Ptr {
 		typ = typ.Elem()
 	}
-
 	if typ.Kind() != reflect.Struct {
-		return nil, fmt.Errorf("expected a struct, got %s", typ.Kind())
+		return nil, false, fmt.Errorf("expected a struct, got %s", typ.Kind())
 	}
 
-	properties := make(map[string]any)
-	var required []string
+	b := &schemaBuilder{strict: true, visiting: make(map[reflect.Type]bool)}
+	if schema, err = b.schema(typ); err != nil {
+		return nil, false, err
+	}
+	return schema, b.strict, nil
+}
+
+// toolFromStruct builds a tool whose parameters are the schema of s, asking for strict
+// mode whenever the schema allows it.
+func toolFromStruct(name, description string, s any) (Tool, error) {
+	schema, strict, err := structToJSONSchema(s)
+	if err != nil {
+		return Tool{}, fmt.Errorf("failed to generate schema for %s: %w", name, err)
+	}
+	return Tool{Name: name, Description: description, Parameters: schema, Strict: strict}, nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaBuilder walks a type, noting anything strict mode can't express.
+type schemaBuilder struct {
+	strict   bool
+	visiting map[reflect.Type]bool // structs being expanded, to catch recursive types
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) (map[string]any, error) {
+	if t.Kind() == reflect.Ptr {
+		schema, err := b.schema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return nullable(schema), nil
+	}
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}, nil
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}, nil
+	case reflect.Slice, reflect.Array:
+		// encoding/json writes byte slices as base64 strings
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}, nil
+		}
+		items, err := b.schema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "array", "items": items}, nil
+	case reflect.Map:
+		switch t.Key().Kind() {
+		case reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		default:
+			return nil, fmt.Errorf("map keys of type %s are not supported", t.Key())
+		}
+		values, err := b.schema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		// Strict mode only allows objects with fixed properties
+		b.strict = false
+		return map[string]any{"type": "object", "additionalProperties": values}, nil
+	case reflect.Struct:
+		return b.object(t)
+	case reflect.Interface:
+		b.strict = false
+		return map[string]any{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported type %s", t)
+	}
+}
+
+func (b *schemaBuilder) object(t reflect.Type) (map[string]any, error) {
+	if b.visiting[t] {
+		return nil, fmt.Errorf("recursive type %s is not supported", t)
+	}
+	b.visiting[t] = true
+	defer delete(b.visiting, t)
 
-	for i := 0; i < typ.NumField(); i++ {
-		field := typ.Field(i)
+	properties := make(map[string]any)
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
 		jsonTag := field.Tag.Get("json")
-		if jsonTag == "" || jsonTag == "-" {
+		if !field.IsExported() || jsonTag == "" || jsonTag == "-" {
 			continue // Skip fields without json tag or marked to be ignored
 		}
+		opts := parseSchemaTag(field.Tag.Get("jsonschema"))
+		if opts.skip {
+			continue
+		}
 
-		parts := strings.Split(jsonTag, ",")
-		fieldName := parts[0]
-
-		prop := make(map[string]any)
+		name, flags, _ := strings.Cut(jsonTag, ",")
+		if name == "" {
+			name = field.Name
+		}
 		fieldType := field.Type
-		if fieldType.Kind() == reflect.Ptr {
+		optional := opts.nullable || fieldType.Kind() == reflect.Ptr || slices.Contains(strings.Split(flags, ","), "omitempty")
+		for fieldType.Kind() == reflect.Ptr {
 			fieldType = fieldType.Elem()
 		}
 
-		switch fieldType.Kind() {
-		case reflect.String:
-			prop["type"] = "string"
-		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
-			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-			prop["type"] = "integer"
-		case reflect.Float32, reflect.Float64:
-			prop["type"] = "number"
-		case reflect.Bool:
-			prop["type"] = "boolean"
-		case reflect.Slice:
-			prop["type"] = "array"
-			elemType := fieldType.Elem()
-			if elemType.Kind() == reflect.Ptr {
-				elemType = elemType.Elem()
-			}
-
-			items := make(map[string]any)
-			switch elemType.Kind() {
-			case reflect.String:
-				items["type"] = "string"
-			default:
-				items["type"] = "string" // Fallback for simplicity
+		prop, err := b.schema(fieldType)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", t.Name(), field.Name, err)
+		}
+		if opts.description != "" {
+			prop["description"] = opts.description
+		}
+		if len(opts.enum) > 0 {
+			values, err := enumValues(fieldType, opts.enum)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", t.Name(), field.Name, err)
 			}
-			prop["items"] = items
-		default:
-			continue
+			prop["enum"] = values
 		}
-		properties[fieldName] = prop
-		required = append(required, fieldName)
+		if optional {
+			prop = nullable(prop)
+		}
+		properties[name] = prop
+		required = append(required, name)
 	}
 
 	return map[string]any{
@@ -234,32 +270,110 @@ func structToJSONSchema(s any) (map[string]any, error) {
 	}, nil
 }
 
+// nullable lets schema also accept null.
+func nullable(schema map[string]any) map[string]any {
+	if typ, ok := schema["type"].(string); ok {
+		schema["type"] = []string{typ, "null"}
+	}
+	if enum, ok := schema["enum"].([]any); ok {
+		schema["enum"] = append(enum, nil)
+	}
+	return schema
+}
+
+// schemaTag holds the options of a jsonschema struct tag.
+type schemaTag struct {
+	skip        bool
+	nullable    bool
+	description string
+	enum        []string
+}
+
+// parseSchemaTag parses a comma-separated jsonschema tag. Descriptions may themselves
+// contain commas, so anything that isn't a known option continues the description.
+func parseSchemaTag(tag string) schemaTag {
+	var opts schemaTag
+	if tag == "-" {
+		opts.skip = true
+		return opts
+	}
+	inDescription := false
+	for _, part := range strings.Split(tag, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch {
+		case key == "description":
+			opts.description, inDescription = value, true
+		case key == "enum":
+			opts.enum, inDescription = strings.Split(value, "|"), false
+		case part == "nullable":
+			opts.nullable, inDescription = true, false
+		case inDescription:
+			opts.description += "," + part
+		}
+	}
+	return opts
+}
+
+// enumValues converts enum tag values to the field's JSON type.
+func enumValues(t reflect.Type, values []string) ([]any, error) {
+	enum := make([]any, 0, len(values))
+	for _, v := range values {
+		switch t.Kind() {
+		case reflect.String:
+			enum = append(enum, v)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid integer enum value %q", v)
+			}
+			enum = append(enum, n)
+		case reflect.Float32, reflect.Float64:
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number enum value %q", v)
+			}
+			enum = append(enum, f)
+		default:
+			return nil, fmt.Errorf("enums are not supported for %s", t)
+		}
+	}
+	return enum, nil
+}
+
 // generateJSONResponse instructs the LLM to respond with a specific JSON structure.
 func generateJSONResponse(ctx context.Context, p OpenAIConfig, prompt, userInput string, targetJSON any) ([]byte, error) {
-	schema, err := structToJSONSchema(targetJSON)
+	tool, err := toolFromStruct("json_response", "A tool to provide a JSON response.", targetJSON)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate schema from target struct: %w", err)
-	}
-	tool := Tool{
-		Name:        "json_response",
-		Description: "A tool to provide a JSON response.",
-		Parameters:  schema,
+		return nil, err
 	}
 
-	toolChoice := "required" // This API expects 'required' to force a tool call.
+	client, err := NewLLMClient(p)
+	if err != nil {
+		return nil, err
+	}
 
-	// We pass the tool and also explicitly ask the model to use it.
-	_, toolCalls, _, err := generateResponsesTurn(ctx, p, "", userInput, []Tool{tool}, nil, toolChoice)
+	// We pass the tool and also force the model to use it.
+	resp, err := client.Generate(ctx, LLMRequest{
+		Messages: []LLMMessage{
+			{Role: RoleSystem, Content: prompt},
+			{Role: RoleUser, Content: userInput},
+		},
+		Tools:      []Tool{tool},
+		ToolChoice: ToolChoiceRequired,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate JSON response: %w", err)
 	}
 
-	if len(toolCalls) == 0 {
+	recordUsage(ctx, p.Model, resp.Usage)
+
+	if len(resp.Calls) == 0 {
 		return nil, fmt.Errorf("LLM did not return the expected tool call")
 	}
 
 	// Extract the arguments from the first tool call.
-	return []byte(toolCalls[0].Arguments), nil
+	return []byte(resp.Calls[0].Arguments), nil
 }
 
 // ParsePollRequestWithLLM uses an LLM to parse a natural language poll request
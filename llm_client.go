@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Names of the LLM backends, selected with RESEARCH_ORCHESTRATOR_LLM_PROVIDER.
+const (
+	LLMProviderResponses = "responses" // OpenAI Responses API
+	LLMProviderChat      = "chat"      // OpenAI-compatible Chat Completions: OpenAI, vLLM, llama.cpp, ...
+	LLMProviderOllama    = "ollama"    // Ollama's Chat Completions endpoint; no API key needed
+	LLMProviderAnthropic = "anthropic" // Anthropic Messages API
+)
+
+// Roles of the messages in an LLM conversation.
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleTool      = "tool"
+)
+
+// Tool choices understood by every backend.
+const (
+	ToolChoiceAuto     = "auto"
+	ToolChoiceRequired = "required"
+)
+
+// defaultLLMMaxTokens bounds each response when OpenAIConfig.MaxTokens is unset.
+const defaultLLMMaxTokens = 4096
+
+// anthropicVersion is the Messages API version we speak.
+const anthropicVersion = "2023-06-01"
+
+// LLMMessage is one message of a conversation. Assistant messages may carry tool calls,
+// and tool messages answer the call with ToolCallID.
+type LLMMessage struct {
+	Role       string     `json:"role"`
+	Content    string     `json:"content,omitempty"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+}
+
+// LLMRequest is a full conversation to continue. The caller owns the history, so backends
+// keep no server-side state between turns.
+type LLMRequest struct {
+	Messages   []LLMMessage `json:"messages"`
+	Tools      []Tool       `json:"tools,omitempty"`
+	ToolChoice string       `json:"tool_choice,omitempty"` // ToolChoiceAuto (default) or ToolChoiceRequired
+}
+
+// LLMResponse is the assistant's turn.
+type LLMResponse struct {
+	Assistant string     `json:"assistant"`
+	Calls     []ToolCall `json:"calls"`
+	ID        string     `json:"id"`
+	Usage     TokenUsage `json:"usage"`
+}
+
+// Message returns the response as an assistant message to append to the conversation.
+func (r LLMResponse) Message() LLMMessage {
+	return LLMMessage{Role: RoleAssistant, Content: r.Assistant, ToolCalls: r.Calls}
+}
+
+// LLMClient generates the next assistant turn of a conversation.
+type LLMClient interface {
+	Generate(ctx context.Context, req LLMRequest) (LLMResponse, error)
+}
+
+// llmProviders maps provider names to client constructors.
+var llmProviders = map[string]func(p OpenAIConfig) LLMClient{
+	LLMProviderResponses: func(p OpenAIConfig) LLMClient { return &responsesClient{p} },
+	LLMProviderChat:      func(p OpenAIConfig) LLMClient { return &chatClient{p} },
+	LLMProviderOllama:    func(p OpenAIConfig) LLMClient { return &chatClient{p} },
+	LLMProviderAnthropic: func(p OpenAIConfig) LLMClient { return &anthropicClient{p} },
+}
+
+// NewLLMClient returns the client for p.Provider. An empty provider means the Responses API.
+func NewLLMClient(p OpenAIConfig) (LLMClient, error) {
+	if p.MaxTokens == 0 {
+		p.MaxTokens = defaultLLMMaxTokens
+	}
+	provider := p.Provider
+	if provider == "" {
+		provider = LLMProviderResponses
+	}
+	newClient, ok := llmProviders[provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown LLM provider %q", provider)
+	}
+	return newClient(p), nil
+}
+
+// postLLM sends body to url and returns the response body, failing on non-200 statuses.
+func postLLM(ctx context.Context, url string, headers map[string]string, body any) ([]byte, error) {
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	httpResp, err := llmHTTPClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer httpResp.Body.Close()
+	respBody, _ := io.ReadAll(httpResp.Body)
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status %d: %s", url, httpResp.StatusCode, string(respBody))
+	}
+	return respBody, nil
+}
+
+// llmHTTPClient sends every LLM request. Timeouts come from the activity context.
+var llmHTTPClient = &http.Client{}
+
+// responsesClient speaks the OpenAI Responses API statelessly (store: false), resending
+// the whole conversation as input items each turn.
+type responsesClient struct {
+	p OpenAIConfig
+}
+
+// Generate implements LLMClient.
+func (c *responsesClient) Generate(ctx context.Context, req LLMRequest) (LLMResponse, error) {
+	var input []map[string]any
+	for _, m := range req.Messages {
+		switch m.Role {
+		case RoleTool:
+			input = append(input, map[string]any{"type": "function_call_output", "call_id": m.ToolCallID, "output": m.Content})
+		case RoleAssistant:
+			if m.Content != "" {
+				input = append(input, map[string]any{"role": RoleAssistant, "content": m.Content})
+			}
+			for _, call := range m.ToolCalls {
+				input = append(input, map[string]any{"type": "function_call", "call_id": call.ID, "name": call.Name, "arguments": call.Arguments})
+			}
+		default:
+			input = append(input, map[string]any{"role": m.Role, "content": m.Content})
+		}
+	}
+
+	body := map[string]any{
+		"model":             c.p.Model,
+		"store":             false,
+		"max_output_tokens": c.p.MaxTokens,
+		"input":             input,
+	}
+	if len(req.Tools) > 0 {
+		tools := make([]map[string]any, 0, len(req.Tools))
+		for _, t := range req.Tools {
+			tools = append(tools, map[string]any{
+				"type":        "function",
+				"name":        t.Name,
+				"description": t.Description,
+				"parameters":  t.Parameters,
+				"strict":      t.Strict,
+			})
+		}
+		body["tools"] = tools
+		if req.ToolChoice != "" {
+			body["tool_choice"] = req.ToolChoice
+		}
+	}
+
+	respBody, err := postLLM(ctx, c.p.APIHost+"/v1/responses", map[string]string{"Authorization": "Bearer " + c.p.APIKey}, body)
+	if err != nil {
+		return LLMResponse{}, fmt.Errorf("responses api: %w", err)
+	}
+	return parseResponsesOutput(respBody)
+}
+
+// chatClient speaks the Chat Completions API, which OpenAI and most self-hosted servers
+// (vLLM, llama.cpp, Ollama) implement.
+type chatClient struct {
+	p OpenAIConfig
+}
+
+// Generate implements LLMClient.
+func (c *chatClient) Generate(ctx context.Context, req LLMRequest) (LLMResponse, error) {
+	messages := make([]map[string]any, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		msg := map[string]any{"role": m.Role, "content": m.Content}
+		if m.Role == RoleTool {
+			msg["tool_call_id"] = m.ToolCallID
+		}
+		if len(m.ToolCalls) > 0 {
+			calls := make([]map[string]any, 0, len(m.ToolCalls))
+			for _, call := range m.ToolCalls {
+				calls = append(calls, map[string]any{
+					"id":       call.ID,
+					"type":     "function",
+					"function": map[string]any{"name": call.Name, "arguments": call.Arguments},
+				})
+			}
+			msg["tool_calls"] = calls
+		}
+		messages = append(messages, msg)
+	}
+
+	body := map[string]any{
+		"model":      c.p.Model,
+		"messages":   messages,
+		"max_tokens": c.p.MaxTokens,
+	}
+	if len(req.Tools) > 0 {
+		tools := make([]map[string]any, 0, len(req.Tools))
+		for _, t := range req.Tools {
+			function := map[string]any{
+				"name":        t.Name,
+				"description": t.Description,
+				"parameters":  t.Parameters,
+			}
+			if t.Strict {
+				function["strict"] = true
+			}
+			tools = append(tools, map[string]any{"type": "function", "function": function})
+		}
+		body["tools"] = tools
+		if req.ToolChoice != "" {
+			body["tool_choice"] = req.ToolChoice
+		}
+	}
+
+	headers := map[string]string{}
+	if c.p.APIKey != "" {
+		headers["Authorization"] = "Bearer " + c.p.APIKey
+	}
+	respBody, err := postLLM(ctx, c.p.APIHost+"/v1/chat/completions", headers, body)
+	if err != nil {
+		return LLMResponse{}, fmt.Errorf("chat completions api: %w", err)
+	}
+
+	var resp struct {
+		ID      string `json:"id"`
+		Choices []struct {
+			Message struct {
+				Content   string `json:"content"`
+				ToolCalls []struct {
+					ID       string `json:"id"`
+					Function struct {
+						Name      string          `json:"name"`
+						Arguments json.RawMessage `json:"arguments"`
+					} `json:"function"`
+				} `json:"tool_calls"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage struct {
+			PromptTokens        int `json:"prompt_tokens"`
+			CompletionTokens    int `json:"completion_tokens"`
+			PromptTokensDetails struct {
+				CachedTokens int `json:"cached_tokens"`
+			} `json:"prompt_tokens_details"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		return LLMResponse{}, fmt.Errorf("failed to decode chat completions body: %w", err)
+	}
+	usage := TokenUsage{
+		Calls:        1,
+		InputTokens:  resp.Usage.PromptTokens,
+		OutputTokens: resp.Usage.CompletionTokens,
+		CachedTokens: resp.Usage.PromptTokensDetails.CachedTokens,
+	}
+	if len(resp.Choices) == 0 {
+		return LLMResponse{ID: resp.ID, Usage: usage}, nil
+	}
+
+	msg := resp.Choices[0].Message
+	out := LLMResponse{Assistant: strings.TrimSpace(msg.Content), ID: resp.ID, Usage: usage}
+	for i, call := range msg.ToolCalls {
+		// OpenAI sends arguments as a JSON string; some servers send the object itself
+		args := string(call.Function.Arguments)
+		var s string
+		if json.Unmarshal(call.Function.Arguments, &s) == nil {
+			args = s
+		}
+		id := call.ID
+		if id == "" {
+			id = fmt.Sprintf("call_%d", i) // Ollama leaves IDs out
+		}
+		out.Calls = append(out.Calls, ToolCall{ID: id, Name: call.Function.Name, Arguments: args})
+	}
+	return out, nil
+}
+
+// anthropicClient speaks the Anthropic Messages API.
+type anthropicClient struct {
+	p OpenAIConfig
+}
+
+// Generate implements LLMClient.
+func (c *anthropicClient) Generate(ctx context.Context, req LLMRequest) (LLMResponse, error) {
+	var system []string
+	var messages []map[string]any
+	// Messages must alternate between user and assistant, and tool results are user
+	// content blocks, so consecutive messages of the same side are merged.
+	appendBlocks := func(role string, blocks ...map[string]any) {
+		if n := len(messages); n > 0 && messages[n-1]["role"] == role {
+			messages[n-1]["content"] = append(messages[n-1]["content"].([]map[string]any), blocks...)
+			return
+		}
+		messages = append(messages, map[string]any{"role": role, "content": blocks})
+	}
+	for _, m := range req.Messages {
+		switch m.Role {
+		case RoleSystem:
+			system = append(system, m.Content)
+		case RoleTool:
+			appendBlocks(RoleUser, map[string]any{"type": "tool_result", "tool_use_id": m.ToolCallID, "content": m.Content})
+		case RoleAssistant:
+			var blocks []map[string]any
+			if m.Content != "" {
+				blocks = append(blocks, map[string]any{"type": "text", "text": m.Content})
+			}
+			for _, call := range m.ToolCalls {
+				input := json.RawMessage(call.Arguments)
+				if !json.Valid(input) {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, map[string]any{"type": "tool_use", "id": call.ID, "name": call.Name, "input": input})
+			}
+			if len(blocks) > 0 {
+				appendBlocks(RoleAssistant, blocks...)
+			}
+		default:
+			appendBlocks(RoleUser, map[string]any{"type": "text", "text": m.Content})
+		}
+	}
+
+	body := map[string]any{
+		"model":      c.p.Model,
+		"max_tokens": c.p.MaxTokens,
+		"messages":   messages,
+	}
+	if len(system) > 0 {
+		body["system"] = strings.Join(system, "\n\n")
+	}
+	if len(req.Tools) > 0 {
+		tools := make([]map[string]any, 0, len(req.Tools))
+		for _, t := range req.Tools {
+			tools = append(tools, map[string]any{
+				"name":         t.Name,
+				"description":  t.Description,
+				"input_schema": t.Parameters,
+			})
+		}
+		body["tools"] = tools
+		if req.ToolChoice == ToolChoiceRequired {
+			body["tool_choice"] = map[string]any{"type": "any"}
+		}
+	}
+
+	headers := map[string]string{"x-api-key": c.p.APIKey, "anthropic-version": anthropicVersion}
+	respBody, err := postLLM(ctx, c.p.APIHost+"/v1/messages", headers, body)
+	if err != nil {
+		return LLMResponse{}, fmt.Errorf("messages api: %w", err)
+	}
+
+	var resp struct {
+		ID      string `json:"id"`
+		Content []struct {
+			Type  string          `json:"type"`
+			Text  string          `json:"text"`
+			ID    string          `json:"id"`
+			Name  string          `json:"name"`
+			Input json.RawMessage `json:"input"`
+		} `json:"content"`
+		Usage struct {
+			InputTokens              int `json:"input_tokens"`
+			OutputTokens             int `json:"output_tokens"`
+			CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+			CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		return LLMResponse{}, fmt.Errorf("failed to decode messages body: %w", err)
+	}
+
+	// Anthropic counts cache reads and writes apart from input_tokens
+	out := LLMResponse{ID: resp.ID, Usage: TokenUsage{
+		Calls:        1,
+		InputTokens:  resp.Usage.InputTokens + resp.Usage.CacheReadInputTokens + resp.Usage.CacheCreationInputTokens,
+		OutputTokens: resp.Usage.OutputTokens,
+		CachedTokens: resp.Usage.CacheReadInputTokens,
+	}}
+	var text []string
+	for _, block := range resp.Content {
+		switch block.Type {
+		case "text":
+			text = append(text, block.Text)
+		case "tool_use":
+			out.Calls = append(out.Calls, ToolCall{ID: block.ID, Name: block.Name, Arguments: string(block.Input)})
+		}
+	}
+	out.Assistant = strings.TrimSpace(strings.Join(text, "\n"))
+	return out, nil
+}
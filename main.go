@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	stdlog "log"
 	"log/slog"
 	"net/http"
@@ -21,6 +22,10 @@ import (
 // appConfig is the global application configuration, loaded once at startup
 var appConfig *Config
 
+// appStorage is the shared object storage client, created once at startup and
+// used by both the API server and the activities
+var appStorage ObjectStorage
+
 func main() {
 	stdlog.Println("Application starting up...")
 
@@ -30,6 +35,10 @@ func main() {
 		stdlog.Fatalf("Failed to load configuration: %v", err)
 	}
 	appConfig = cfg // Set global config
+	appStorage = NewObjectStorage(cfg)
+	if err := installFixtures(cfg); err != nil {
+		stdlog.Fatalf("Failed to set up fixtures: %v", err)
+	}
 	stdlog.Println("Configuration loaded and validated successfully")
 
 	// Setup signal handling for graceful shutdown
@@ -75,8 +84,7 @@ func main() {
 
 			bucket := cfg.StorageBucket
 
-			storage := NewObjectStorage(cfg)
-			s3Storage, ok := storage.(*S3CompatibleStorage)
+			s3Storage, ok := appStorage.(*S3CompatibleStorage)
 			if !ok {
 				stdlog.Fatalf("setup-bucket only works with S3-compatible storage")
 			}
@@ -86,6 +94,45 @@ func main() {
 				stdlog.Fatalf("Failed to setup bucket: %v", err)
 			}
 			stdlog.Printf("Successfully configured bucket '%s' for public read access", bucket)
+		case "gc":
+			gcCmd := flag.NewFlagSet("gc", flag.ExitOnError)
+			keepImages := gcCmd.Int("keep-images", 5, "number of newest generated images to keep per user")
+			pollRetentionDays := gcCmd.Int("poll-retention-days", 30, "days to keep a poll's folder after the poll closes")
+			dryRun := gcCmd.Bool("dry-run", false, "print what would be deleted without deleting anything")
+			gcCmd.Parse(os.Args[2:])
+
+			c := newTemporalClient(cfg)
+			defer c.Close()
+
+			result, err := RunStorageGC(ctx, c, appStorage, cfg.StorageBucket, GCOptions{
+				KeepImagesPerUser: *keepImages,
+				PollRetention:     time.Duration(*pollRetentionDays) * 24 * time.Hour,
+				DryRun:            *dryRun,
+			})
+			if err != nil {
+				stdlog.Fatalf("Storage garbage collection failed: %v", err)
+			}
+			if *dryRun {
+				stdlog.Printf("Dry run complete: %d prefixes would be deleted, %d folders kept", len(result.Deleted), result.Kept)
+			} else {
+				stdlog.Printf("Garbage collection complete: %d prefixes deleted, %d folders kept", len(result.Deleted), result.Kept)
+			}
+		case "inspect":
+			inspectCmd := flag.NewFlagSet("inspect", flag.ExitOnError)
+			key := inspectCmd.String("key", "", "storage key of the object to inspect (required)")
+			inspectCmd.Parse(os.Args[2:])
+
+			if *key == "" {
+				stdlog.Fatalf("key is required. Usage: %s inspect -key <storage-key>", os.Args[0])
+			}
+
+			info, err := appStorage.StatInfo(ctx, cfg.StorageBucket, *key)
+			if err != nil {
+				stdlog.Fatalf("Failed to inspect object: %v", err)
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(info)
 		default:
 			stdlog.Fatalf("Unknown command: %s", os.Args[1])
 		}
@@ -122,10 +169,17 @@ func runWorker(ctx context.Context, cfg *Config, wg *sync.WaitGroup) {
 	w.RegisterWorkflow(GeneratePollImagesWorkflow)
 	w.RegisterActivity(GenerateContentGenerationPrompt)
 	w.RegisterActivity(GenerateContent)
+	w.RegisterActivity(FetchGitHubAvatar)
+	w.RegisterActivity(RenderContributionAnimation)
+	w.RegisterActivity(RecordUsage)
 	w.RegisterActivity(StoreContent)
 	w.RegisterActivity(ExecuteGhCommandActivity)
-	w.RegisterActivity(GenerateResponsesTurnActivity)
+	w.RegisterActivity(GenerateLLMTurnActivity)
 	w.RegisterActivity(CopyObject)
+	w.RegisterActivity(LinkPollImage)
+	w.RegisterActivity(ComposePollCollage)
+	w.RegisterActivity(ArchivePollManifest)
+	w.RegisterActivity(TeardownPollFolder)
 	w.RegisterActivity(WaitForPayment)
 
 	// Start worker
@@ -147,8 +201,7 @@ func runServer(ctx context.Context, stop context.CancelFunc, cfg *Config, wg *sy
 	defer c.Close()
 
 	// Create API server
-	storage := NewObjectStorage(cfg)
-	apiServer := NewAPIServer(c, storage, cfg)
+	apiServer := NewAPIServer(c, appStorage, cfg)
 
 	// Setup routes
 	apiServer = apiServer.SetupRoutes()
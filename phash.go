@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+	"strconv"
+
+	"github.com/nfnt/resize"
+)
+
+const (
+	// nearDuplicateThreshold is the largest dHash Hamming distance (out of 64 bits) at which
+	// two images count as the same meme.
+	nearDuplicateThreshold = 10
+	// maxDuplicateRegenerations bounds how often a near-duplicate poll image is regenerated.
+	maxDuplicateRegenerations = 2
+)
+
+// perceptualHash decodes an image and returns its dHash as 16 hex digits.
+func perceptualHash(data []byte) (string, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode image for hashing: %w", err)
+	}
+	return fmt.Sprintf("%016x", dHash(img)), nil
+}
+
+// dHash computes a 64-bit difference hash: the image is shrunk to 9x8 grayscale and each
+// bit records whether a pixel is brighter than its right neighbour. Re-encoding, resizing
+// and small edits barely change it, so similar images have a small Hamming distance.
+func dHash(img image.Image) uint64 {
+	small := resize.Resize(9, 8, img, resize.Bilinear)
+	b := small.Bounds()
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			left := color.GrayModel.Convert(small.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y
+			right := color.GrayModel.Convert(small.At(b.Min.X+x+1, b.Min.Y+y)).(color.Gray).Y
+			hash <<= 1
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// hashDistance returns the Hamming distance between two hex-encoded dHashes.
+func hashDistance(a, b string) (int, error) {
+	x, err := strconv.ParseUint(a, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid perceptual hash %q: %w", a, err)
+	}
+	y, err := strconv.ParseUint(b, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid perceptual hash %q: %w", b, err)
+	}
+	return bits.OnesCount64(x ^ y), nil
+}
+
+// pollImageHash is the perceptual hash of a poll option's image.
+type pollImageHash struct {
+	Username string
+	Hash     string
+}
+
+// nearestDuplicate returns the option whose image is closest to hash, if it is within
+// nearDuplicateThreshold, along with the distance. It returns "" when there is none.
+func nearestDuplicate(hash string, others []pollImageHash) (string, int) {
+	match, best := "", nearDuplicateThreshold+1
+	if hash == "" {
+		return match, best
+	}
+	for _, other := range others {
+		d, err := hashDistance(hash, other.Hash)
+		if err != nil || d >= best {
+			continue
+		}
+		match, best = other.Username, d
+	}
+	return match, best
+}
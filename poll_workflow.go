@@ -21,14 +21,27 @@ type PollConfig struct {
 	PaymentRequired bool    // if true, poll requires payment before accepting votes
 	PaymentWallet   string  // Solana wallet address to receive payment
 	PaymentAmount   float64 // Amount in SOL required for payment
+	// Teardown-related fields
+	TeardownMode     string // what to do with the poll folder once the poll closes: "" (keep), PollTeardownArchive or PollTeardownDelete
+	RetentionSeconds int    // how long to keep the poll folder after the poll closes before tearing it down
+	// Budget-related fields
+	LLMBudget LLMBudget // caps the research agent of each username, not the poll as a whole
 }
 
+const (
+	// PollTeardownArchive moves the poll's images under archive/<pollID>/ next to the results manifest.
+	PollTeardownArchive = "archive"
+	// PollTeardownDelete deletes the poll's images, keeping only the results manifest.
+	PollTeardownDelete = "delete"
+)
+
 // PollState is the dynamic state of a poll.
 type PollState struct {
 	Options      map[string]int
 	Voters       map[string]struct{}
 	PaymentPaid  bool   // true if payment has been received
 	PaymentTxnID string // Solana transaction ID of the payment
+	Closed       bool   // true once the poll has ended and no longer accepts votes
 }
 
 // PollSummary is now defined in types.go
@@ -113,6 +126,9 @@ func PollWorkflow(ctx workflow.Context, config PollConfig) (PollSummary, error)
 	}
 
 	err = workflow.SetUpdateHandler(ctx, "vote", func(ctx workflow.Context, update VoteUpdate) (VoteUpdateResult, error) {
+		if state.Closed {
+			return VoteUpdateResult{}, fmt.Errorf("poll is closed")
+		}
 		// Check if payment is required but not yet received
 		if config.PaymentRequired && !state.PaymentPaid {
 			return VoteUpdateResult{}, fmt.Errorf("poll requires payment before voting - please complete payment first")
@@ -187,17 +203,20 @@ func PollWorkflow(ctx workflow.Context, config PollConfig) (PollSummary, error)
 	}
 
 	// Start image generation workflow as a child
+	var imageGenFuture workflow.ChildWorkflowFuture
+	imageGenCtx, cancelImageGen := workflow.WithCancel(ctx)
 	if len(config.Usernames) > 0 {
 		logger.Info("Starting image generation child workflow", "usernames", config.Usernames)
 
 		childWorkflowOptions := workflow.ChildWorkflowOptions{
 			WorkflowID: "g2i-poll-image-generation-" + workflow.GetInfo(ctx).WorkflowExecution.ID,
 		}
-		childCtx := workflow.WithChildOptions(ctx, childWorkflowOptions)
+		childCtx := workflow.WithChildOptions(imageGenCtx, childWorkflowOptions)
 
 		imageGenInput := PollImageGenerationInput{
 			Usernames: config.Usernames,
 			PollID:    workflow.GetInfo(ctx).WorkflowExecution.ID,
+			Question:  config.Question,
 			AppInput: AppInput{
 				ModelName:                     appConfig.GeminiModel,
 				ResearchAgentSystemPrompt:     appConfig.ResearchAgentPrompt,
@@ -207,10 +226,16 @@ func PollWorkflow(ctx workflow.Context, config PollConfig) (PollSummary, error)
 				ImageFormat:                   appConfig.ImageFormat,
 				ImageWidth:                    appConfig.ImageWidth,
 				ImageHeight:                   appConfig.ImageHeight,
+				Watermark:                     appConfig.WatermarkImages,
+				ImageCandidates:               appConfig.ImageCandidates,
+				ImageMaxAttempts:              appConfig.ImageMaxAttempts,
+				ImageScorer:                   appConfig.ImageScorer,
+				UseAvatar:                     appConfig.ImageUseAvatar,
+				LLMBudget:                     config.LLMBudget,
 			},
 		}
 
-		_ = workflow.ExecuteChildWorkflow(childCtx, GeneratePollImagesWorkflow, imageGenInput)
+		imageGenFuture = workflow.ExecuteChildWorkflow(childCtx, GeneratePollImagesWorkflow, imageGenInput)
 
 		// Don't wait for image generation to complete - let it run in background
 		// The workflow can continue accepting votes while images are being generated
@@ -287,12 +312,72 @@ func PollWorkflow(ctx workflow.Context, config PollConfig) (PollSummary, error)
 			return PollSummary{}, ctx.Err()
 		}
 	}
+	state.Closed = true
+
 	// the poll should return summary information to the client
 	summary := PollSummary{
 		Question: config.Question,
 		Options:  state.Options,
 		Voters:   state.Voters,
 	}
+
+	if config.TeardownMode != "" {
+		// Image generation may still be copying into the poll folder; stop it first
+		if imageGenFuture != nil && !imageGenFuture.IsReady() {
+			logger.Info("Cancelling image generation before teardown.")
+			cancelImageGen()
+			_ = imageGenFuture.Get(ctx, nil)
+		}
+		if err := teardownPoll(ctx, config, summary); err != nil {
+			// The votes are already tallied; a failed teardown shouldn't fail the poll
+			logger.Error("Poll teardown failed", "error", err)
+		}
+	}
 	return summary, nil
 }
 
+// teardownPoll archives a results manifest and then archives or deletes the poll folder.
+func teardownPoll(ctx workflow.Context, config PollConfig, summary PollSummary) error {
+	logger := workflow.GetLogger(ctx)
+	pollID := workflow.GetInfo(ctx).WorkflowExecution.ID
+
+	if config.RetentionSeconds > 0 {
+		logger.Info("Retaining poll folder before teardown.", "seconds", config.RetentionSeconds)
+		if err := workflow.Sleep(ctx, time.Second*time.Duration(config.RetentionSeconds)); err != nil {
+			return err
+		}
+	}
+
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: 5 * time.Minute,
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	manifest := PollManifest{
+		PollID:   pollID,
+		Question: summary.Question,
+		Options:  summary.Options,
+		ClosedAt: workflow.Now(ctx),
+	}
+	archiveInput := ArchivePollManifestInput{
+		Bucket:   appConfig.StorageBucket,
+		Manifest: manifest,
+	}
+	var manifestKey string
+	if err := workflow.ExecuteActivity(ctx, ArchivePollManifest, archiveInput).Get(ctx, &manifestKey); err != nil {
+		return fmt.Errorf("failed to archive poll manifest: %w", err)
+	}
+	logger.Info("Archived poll manifest.", "key", manifestKey)
+
+	teardownInput := TeardownPollFolderInput{
+		Bucket: appConfig.StorageBucket,
+		PollID: pollID,
+		Mode:   config.TeardownMode,
+	}
+	if err := workflow.ExecuteActivity(ctx, TeardownPollFolder, teardownInput).Get(ctx, nil); err != nil {
+		return fmt.Errorf("failed to tear down poll folder: %w", err)
+	}
+	logger.Info("Poll folder torn down.", "mode", config.TeardownMode)
+	return nil
+}
+
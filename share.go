@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"go.temporal.io/api/enums/v1"
+)
+
+const (
+	// siteName is shown as the provider in link previews and oEmbed responses.
+	siteName = "Vibe Check"
+	// fallbackShareImage is used for polls whose collage hasn't been composed yet.
+	fallbackShareImage = "/static/images/splashpage.png"
+	// oembedDefaultWidth is the embed width used when the consumer sets no maxwidth.
+	oembedDefaultWidth = 600
+	// maxDescriptionLength keeps descriptions within what unfurlers display.
+	maxDescriptionLength = 200
+)
+
+// PageMeta holds the Open Graph and Twitter card tags rendered by base.html.
+type PageMeta struct {
+	Title       string
+	Description string
+	URL         string
+	Type        string // og:type, e.g. "website" or "profile"
+	ImageURL    string
+	ImageWidth  int // zero when unknown
+	ImageHeight int
+	OEmbedURL   string
+}
+
+// OEmbedResponse is the JSON body of an oEmbed response (https://oembed.com).
+type OEmbedResponse struct {
+	Type            string `json:"type"`
+	Version         string `json:"version"`
+	Title           string `json:"title,omitempty"`
+	ProviderName    string `json:"provider_name"`
+	ProviderURL     string `json:"provider_url"`
+	URL             string `json:"url,omitempty"`  // photo only
+	HTML            string `json:"html,omitempty"` // rich only
+	Width           int    `json:"width"`
+	Height          int    `json:"height"`
+	ThumbnailURL    string `json:"thumbnail_url,omitempty"`
+	ThumbnailWidth  int    `json:"thumbnail_width,omitempty"`
+	ThumbnailHeight int    `json:"thumbnail_height,omitempty"`
+}
+
+// baseURL returns the absolute URL the site is served from. Link previews need absolute
+// URLs, so PUBLIC_BASE_URL is used when set and the request's host otherwise.
+func (s *APIServer) baseURL(r *http.Request) string {
+	if s.cfg.PublicBaseURL != "" {
+		return strings.TrimSuffix(s.cfg.PublicBaseURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
+
+// oembedURL returns the oEmbed discovery URL for a page.
+func oembedURL(base, pageURL string) string {
+	return base + "/oembed?format=json&url=" + url.QueryEscape(pageURL)
+}
+
+// pollMeta builds the link preview tags for a poll page. The poll's collage is used as the
+// share image once it exists.
+func (s *APIServer) pollMeta(r *http.Request, workflowID, question string) PageMeta {
+	base := s.baseURL(r)
+	pageURL := base + "/poll/" + url.PathEscape(workflowID)
+	meta := PageMeta{
+		Title:       question,
+		Description: "Vote on " + question,
+		URL:         pageURL,
+		Type:        "website",
+		ImageURL:    base + fallbackShareImage,
+		OEmbedURL:   oembedURL(base, pageURL),
+	}
+	if _, err := s.storageProvider.Stat(r.Context(), s.cfg.StorageBucket, collageKey(workflowID)); err == nil {
+		meta.ImageURL = pageURL + "/share-image"
+		meta.ImageWidth, meta.ImageHeight = collageWidth, collageHeight
+	}
+	return meta
+}
+
+// profileMeta builds the link preview tags for a completed profile page.
+func (s *APIServer) profileMeta(r *http.Request, username string, result AppOutput) PageMeta {
+	base := s.baseURL(r)
+	pageURL := base + "/profile/" + url.PathEscape(username)
+	description := result.GitHubProfile.ProfessionalSummary
+	if description == "" {
+		description = result.GitHubProfile.Bio
+	}
+	meta := PageMeta{
+		Title:       "Profile for " + username,
+		Description: truncateString(description, maxDescriptionLength),
+		URL:         pageURL,
+		Type:        "profile",
+		OEmbedURL:   oembedURL(base, pageURL),
+	}
+	if _, v := profileShareImage(result); v.Key != "" {
+		meta.ImageURL = pageURL + "/share-image"
+		meta.ImageWidth, meta.ImageHeight = v.Width, v.Height
+	}
+	return meta
+}
+
+// profileShareImage returns the rendition of a profile image used for link previews: the
+// og crop when it exists, otherwise the full image.
+func profileShareImage(result AppOutput) (string, ImageVariant) {
+	if v, ok := result.Variants[VariantOG]; ok {
+		return VariantOG, v
+	}
+	if v, ok := result.Variants[VariantFull]; ok {
+		return VariantFull, v
+	}
+	return VariantFull, ImageVariant{Key: result.StorageKey, ContentType: result.ContentType, Width: result.ImageWidth, Height: result.ImageHeight}
+}
+
+// handleGetPollShareImage redirects to a poll's collage, or to the splash image while the
+// collage is still being composed. Share images need a stable URL since storage URLs expire.
+func (s *APIServer) handleGetPollShareImage() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		workflowID := r.PathValue("id")
+		if len(workflowID) > MaxWorkflowIDLength {
+			s.writeBadRequest(w, r, "Invalid poll ID.")
+			return
+		}
+
+		imageURL, err := s.storageProvider.Stat(r.Context(), s.cfg.StorageBucket, collageKey(workflowID))
+		if err != nil {
+			s.logger.Debug("Poll collage not found", "workflow_id", workflowID, "error", err)
+			imageURL = fallbackShareImage
+		}
+
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		http.Redirect(w, r, imageURL, http.StatusFound)
+	})
+}
+
+// handleGetProfileShareImage redirects to the link preview image of a completed profile.
+func (s *APIServer) handleGetProfileShareImage() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username := r.PathValue("username")
+		if len(username) > MaxGitHubUsernameLength {
+			s.writeBadRequest(w, r, "Invalid username.")
+			return
+		}
+
+		result, err := s.completedProfileResult(r.Context(), username)
+		if err != nil {
+			s.logger.Debug("Profile result not found", "username", username, "error", err)
+			http.NotFound(w, r)
+			return
+		}
+		_, v := profileShareImage(result)
+		imageURL, err := s.storageProvider.Stat(r.Context(), s.cfg.StorageBucket, v.Key)
+		if err != nil {
+			s.logger.Debug("Profile share image not found", "key", v.Key, "error", err)
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		http.Redirect(w, r, imageURL, http.StatusFound)
+	})
+}
+
+// handleOEmbed implements the oEmbed endpoint for poll and profile URLs. Polls are returned
+// as a rich card linking to the poll, profiles as a photo.
+func (s *APIServer) handleOEmbed() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if format := query.Get("format"); format != "" && format != "json" {
+			http.Error(w, "Only the json format is supported", http.StatusNotImplemented)
+			return
+		}
+
+		target, err := url.Parse(query.Get("url"))
+		if err != nil || target.Path == "" {
+			s.writeJSONBadRequest(w, "url must be a poll or profile URL")
+			return
+		}
+		maxWidth, _ := strconv.Atoi(query.Get("maxwidth"))
+		maxHeight, _ := strconv.Atoi(query.Get("maxheight"))
+
+		base := s.baseURL(r)
+		var resp OEmbedResponse
+		switch {
+		case strings.HasPrefix(target.Path, "/poll/"):
+			resp, err = s.pollOEmbed(r.Context(), base, strings.TrimPrefix(target.Path, "/poll/"), maxWidth, maxHeight)
+		case strings.HasPrefix(target.Path, "/profile/"):
+			resp, err = s.profileOEmbed(r.Context(), base, strings.TrimPrefix(target.Path, "/profile/"), maxWidth, maxHeight)
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			s.logger.Debug("oEmbed target not found", "url", target.String(), "error", err)
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		s.writeOK(w, resp)
+	})
+}
+
+// pollOEmbed describes a poll as a rich embed: its share image linking to the poll page.
+func (s *APIServer) pollOEmbed(ctx context.Context, base, workflowID string, maxWidth, maxHeight int) (OEmbedResponse, error) {
+	if workflowID == "" || strings.Contains(workflowID, "/") || len(workflowID) > MaxWorkflowIDLength {
+		return OEmbedResponse{}, fmt.Errorf("invalid poll ID %q", workflowID)
+	}
+	config, err := QueryPollWorkflowWithContext[PollConfig](ctx, s.temporalClient, workflowID, "get_config")
+	if err != nil {
+		return OEmbedResponse{}, err
+	}
+
+	pageURL := base + "/poll/" + url.PathEscape(workflowID)
+	imageURL := pageURL + "/share-image"
+	width, height := oembedSize(collageWidth, collageHeight, maxWidth, maxHeight)
+	card := fmt.Sprintf(`<a href="%s" target="_blank" rel="noopener"><img src="%s" width="%d" height="%d" alt="%s" style="max-width:100%%;height:auto;border:0"></a>`,
+		html.EscapeString(pageURL), html.EscapeString(imageURL), width, height, html.EscapeString(config.Question))
+
+	return OEmbedResponse{
+		Type:            "rich",
+		Version:         "1.0",
+		Title:           config.Question,
+		ProviderName:    siteName,
+		ProviderURL:     base,
+		HTML:            card,
+		Width:           width,
+		Height:          height,
+		ThumbnailURL:    imageURL,
+		ThumbnailWidth:  collageWidth,
+		ThumbnailHeight: collageHeight,
+	}, nil
+}
+
+// profileOEmbed describes a completed profile as a photo embed of its share image.
+func (s *APIServer) profileOEmbed(ctx context.Context, base, username string, maxWidth, maxHeight int) (OEmbedResponse, error) {
+	if username == "" || strings.Contains(username, "/") || len(username) > MaxGitHubUsernameLength {
+		return OEmbedResponse{}, fmt.Errorf("invalid username %q", username)
+	}
+	result, err := s.completedProfileResult(ctx, username)
+	if err != nil {
+		return OEmbedResponse{}, err
+	}
+
+	_, v := profileShareImage(result)
+	width, height := oembedSize(v.Width, v.Height, maxWidth, maxHeight)
+	return OEmbedResponse{
+		Type:         "photo",
+		Version:      "1.0",
+		Title:        "Profile for " + username,
+		ProviderName: siteName,
+		ProviderURL:  base,
+		URL:          base + "/profile/" + url.PathEscape(username) + "/share-image",
+		Width:        width,
+		Height:       height,
+	}, nil
+}
+
+// completedProfileResult returns the result of username's content generation workflow.
+// It checks the workflow's status first so a profile still being generated fails fast
+// instead of blocking until the workflow finishes.
+func (s *APIServer) completedProfileResult(ctx context.Context, username string) (AppOutput, error) {
+	workflowID := "content-generation-" + username
+	desc, err := s.temporalClient.DescribeWorkflowExecution(ctx, workflowID, "")
+	if err != nil {
+		return AppOutput{}, fmt.Errorf("failed to describe workflow: %w", err)
+	}
+	if desc.WorkflowExecutionInfo.Status != enums.WORKFLOW_EXECUTION_STATUS_COMPLETED {
+		return AppOutput{}, fmt.Errorf("profile for %s is not complete", username)
+	}
+	var result AppOutput
+	if err := s.temporalClient.GetWorkflow(ctx, workflowID, "").Get(ctx, &result); err != nil {
+		return AppOutput{}, fmt.Errorf("failed to get workflow result: %w", err)
+	}
+	return result, nil
+}
+
+// oembedSize scales a width x height image to the default embed width, shrinking it
+// further to respect the consumer's maxwidth and maxheight while keeping the aspect ratio.
+func oembedSize(width, height, maxWidth, maxHeight int) (int, int) {
+	if width <= 0 || height <= 0 {
+		width, height = collageWidth, collageHeight
+	}
+	w := oembedDefaultWidth
+	if maxWidth > 0 && maxWidth < w {
+		w = maxWidth
+	}
+	h := w * height / width
+	if maxHeight > 0 && h > maxHeight {
+		h = maxHeight
+		w = h * width / height
+	}
+	return w, h
+}
@@ -3,25 +3,88 @@ package main
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
+	gcs "cloud.google.com/go/storage"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
 )
 
 // ObjectStorage defines the interface for object storage operations
 type ObjectStorage interface {
 	Store(ctx context.Context, data []byte, bucket, key, contentType string) (string, error)
+	// Put streams r into the object and returns its public URL. size may be -1 if unknown.
+	Put(ctx context.Context, bucket, key string, r io.Reader, size int64, contentType string, metadata map[string]string) (string, error)
+	// Get opens the object for reading. The caller must close the returned reader.
+	Get(ctx context.Context, bucket, key string) (io.ReadCloser, ObjectInfo, error)
 	List(ctx context.Context, bucket, prefix string) ([]string, error)
 	ListTopLevelFolders(ctx context.Context, bucket string) ([]string, error)
 	GetLatestObjectKeyForUser(ctx context.Context, bucket, username string) (string, error)
 	Copy(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string) error
 	Delete(ctx context.Context, bucket, prefix string) error
+	// DeleteObject removes the single object at key. Deleting a missing object is not an error.
+	DeleteObject(ctx context.Context, bucket, key string) error
 	GetURL(bucket, key string) string
 	GetPresignedURL(ctx context.Context, bucket, key string, expires time.Duration) (string, error)
 	Stat(ctx context.Context, bucket, key string) (string, error)
+	// StatInfo is like Stat but returns the object's size, content type and user metadata.
+	StatInfo(ctx context.Context, bucket, key string) (ObjectInfo, error)
+}
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key          string            `json:"key"`
+	Size         int64             `json:"size"`
+	ContentType  string            `json:"content_type"`
+	LastModified time.Time         `json:"last_modified"`
+	ETag         string            `json:"etag,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"` // user metadata, keys lowercased
+}
+
+// User metadata keys recorded on generated content so every image can be traced
+// back to the profile, workflow run and prompt that produced it.
+const (
+	MetaGitHubUsername = "github-username"
+	MetaWorkflowID     = "workflow-id"
+	MetaRunID          = "run-id"
+	MetaModelName      = "model-name"
+	MetaPromptSHA256   = "prompt-sha256"
+	MetaCreatedAt      = "created-at" // RFC 3339
+	MetaPerceptualHash = "dhash"      // 64-bit difference hash of the image, 16 hex digits
+)
+
+// normalizeMetadata lowercases metadata keys. S3 returns user metadata keys in
+// canonical header form, so this keeps reads consistent across providers.
+func normalizeMetadata(m map[string]string) map[string]string {
+	if len(m) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[strings.ToLower(k)] = v
+	}
+	return out
+}
+
+// minioObjectInfo converts a minio object description to an ObjectInfo.
+func minioObjectInfo(info minio.ObjectInfo) ObjectInfo {
+	return ObjectInfo{
+		Key:          info.Key,
+		Size:         info.Size,
+		ContentType:  info.ContentType,
+		LastModified: info.LastModified,
+		ETag:         info.ETag,
+		Metadata:     normalizeMetadata(info.UserMetadata),
+	}
 }
 
 const (
@@ -31,7 +94,8 @@ const (
 	S3PlatformGCS   = "gcs"
 )
 
-// S3CompatibleStorage implements ObjectStorage using S3-compatible storage
+// S3CompatibleStorage implements ObjectStorage using S3-compatible storage. It is
+// safe for concurrent use; the underlying client is created once and reused.
 type S3CompatibleStorage struct {
 	Platform       string
 	Endpoint       string
@@ -40,6 +104,11 @@ type S3CompatibleStorage struct {
 	AccessKey      string
 	SecretKey      string
 	UseSSL         bool
+
+	clientOnce sync.Once
+	minio      *minio.Client
+	clientErr  error
+	buckets    sync.Map // bucket name -> struct{}, buckets known to exist
 }
 
 // NewS3CompatibleStorage creates a new S3-compatible storage instance
@@ -55,52 +124,70 @@ func NewS3CompatibleStorage(cfg *Config) *S3CompatibleStorage {
 	}
 }
 
-// Store stores content in S3-compatible storage and returns the URL
-func (s *S3CompatibleStorage) Store(ctx context.Context, data []byte, bucket, key, contentType string) (string, error) {
-	// Create S3-compatible client
-	client, err := minio.New(s.Endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(s.AccessKey, s.SecretKey, ""),
-		Secure: s.UseSSL,
-		Region: s.Region,
+// client returns the shared S3-compatible client, creating it on first use.
+func (s *S3CompatibleStorage) client() (*minio.Client, error) {
+	s.clientOnce.Do(func() {
+		s.minio, s.clientErr = minio.New(s.Endpoint, &minio.Options{
+			Creds:  credentials.NewStaticV4(s.AccessKey, s.SecretKey, ""),
+			Secure: s.UseSSL,
+			Region: s.Region,
+		})
+		if s.clientErr != nil {
+			s.clientErr = fmt.Errorf("failed to create S3-compatible client: %w", s.clientErr)
+		}
 	})
-	if err != nil {
-		return "", fmt.Errorf("failed to create S3-compatible client: %w", err)
+	return s.minio, s.clientErr
+}
+
+// ensureBucket creates the bucket with a public read policy if it doesn't exist.
+// Buckets seen once are remembered so uploads don't pay for the check again.
+func (s *S3CompatibleStorage) ensureBucket(ctx context.Context, client *minio.Client, bucket string) error {
+	if _, ok := s.buckets.Load(bucket); ok {
+		return nil
 	}
 
-	// Check if bucket exists, create if not
 	exists, err := client.BucketExists(ctx, bucket)
 	if err != nil {
-		return "", fmt.Errorf("failed to check bucket existence: %w", err)
+		return fmt.Errorf("failed to check bucket existence: %w", err)
 	}
 	if !exists {
 		err = client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{
 			Region: s.Region,
 		})
 		if err != nil {
-			return "", fmt.Errorf("failed to create bucket: %w", err)
+			return fmt.Errorf("failed to create bucket: %w", err)
 		}
 
-		// Set bucket policy to allow public read access
-		policy := fmt.Sprintf(`{
-			"Version": "2012-10-17",
-			"Statement": [
-				{
-					"Effect": "Allow",
-					"Principal": {"AWS": ["*"]},
-					"Action": ["s3:GetObject"],
-					"Resource": ["arn:aws:s3:::%s/*"]
-				}
-			]
-		}`, bucket)
-		err = client.SetBucketPolicy(ctx, bucket, policy)
-		if err != nil {
-			return "", fmt.Errorf("failed to set bucket policy: %w", err)
+		if err := s.setBucketPublicRead(ctx, client, bucket); err != nil {
+			return err
 		}
 	}
 
+	s.buckets.Store(bucket, struct{}{})
+	return nil
+}
+
+// Store stores content in S3-compatible storage and returns the URL
+func (s *S3CompatibleStorage) Store(ctx context.Context, data []byte, bucket, key, contentType string) (string, error) {
+	return s.Put(ctx, bucket, key, bytes.NewReader(data), int64(len(data)), contentType, nil)
+}
+
+// Put streams content into S3-compatible storage and returns the URL
+func (s *S3CompatibleStorage) Put(ctx context.Context, bucket, key string, r io.Reader, size int64, contentType string, metadata map[string]string) (string, error) {
+	// Get the shared S3-compatible client
+	client, err := s.client()
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.ensureBucket(ctx, client, bucket); err != nil {
+		return "", err
+	}
+
 	// Upload content to S3-compatible storage
-	_, err = client.PutObject(ctx, bucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
-		ContentType: contentType,
+	_, err = client.PutObject(ctx, bucket, key, r, size, minio.PutObjectOptions{
+		ContentType:  contentType,
+		UserMetadata: metadata,
 	})
 	if err != nil {
 		return "", fmt.Errorf("failed to upload to S3-compatible storage: %w", err)
@@ -109,34 +196,57 @@ func (s *S3CompatibleStorage) Store(ctx context.Context, data []byte, bucket, ke
 	return s.GetURL(bucket, key), nil
 }
 
-// Stat checks if an object exists and returns its public URL if it does.
-func (s *S3CompatibleStorage) Stat(ctx context.Context, bucket, key string) (string, error) {
-	client, err := minio.New(s.Endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(s.AccessKey, s.SecretKey, ""),
-		Secure: s.UseSSL,
-		Region: s.Region,
-	})
+// Get opens an object in S3-compatible storage for reading.
+func (s *S3CompatibleStorage) Get(ctx context.Context, bucket, key string) (io.ReadCloser, ObjectInfo, error) {
+	client, err := s.client()
 	if err != nil {
-		return "", fmt.Errorf("failed to create S3-compatible client: %w", err)
+		return nil, ObjectInfo{}, err
 	}
+	return getMinioObject(ctx, client, bucket, key)
+}
 
-	_, err = client.StatObject(ctx, bucket, key, minio.StatObjectOptions{})
+// getMinioObject opens an object and stats it up front so missing objects
+// fail here rather than on the first Read.
+func getMinioObject(ctx context.Context, client *minio.Client, bucket, key string) (io.ReadCloser, ObjectInfo, error) {
+	obj, err := client.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
 	if err != nil {
-		return "", fmt.Errorf("object %s not found in bucket %s: %w", key, bucket, err)
+		return nil, ObjectInfo{}, fmt.Errorf("failed to get object %s from bucket %s: %w", key, bucket, err)
 	}
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, ObjectInfo{}, fmt.Errorf("object %s not found in bucket %s: %w", key, bucket, err)
+	}
+	return obj, minioObjectInfo(info), nil
+}
 
+// Stat checks if an object exists and returns its public URL if it does.
+func (s *S3CompatibleStorage) Stat(ctx context.Context, bucket, key string) (string, error) {
+	if _, err := s.StatInfo(ctx, bucket, key); err != nil {
+		return "", err
+	}
 	return s.GetURL(bucket, key), nil
 }
 
+// StatInfo returns an object's attributes and user metadata.
+func (s *S3CompatibleStorage) StatInfo(ctx context.Context, bucket, key string) (ObjectInfo, error) {
+	client, err := s.client()
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	info, err := client.StatObject(ctx, bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("object %s not found in bucket %s: %w", key, bucket, err)
+	}
+	return minioObjectInfo(info), nil
+}
+
 // List lists objects in an S3-compatible bucket with a given prefix.
 func (s *S3CompatibleStorage) List(ctx context.Context, bucket, prefix string) ([]string, error) {
-	client, err := minio.New(s.Endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(s.AccessKey, s.SecretKey, ""),
-		Secure: s.UseSSL,
-		Region: s.Region,
-	})
+	client, err := s.client()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create S3-compatible client: %w", err)
+		return nil, err
 	}
 
 	objectCh := client.ListObjects(ctx, bucket, minio.ListObjectsOptions{
@@ -156,13 +266,9 @@ func (s *S3CompatibleStorage) List(ctx context.Context, bucket, prefix string) (
 
 // ListTopLevelFolders lists "directories" at the root of a bucket.
 func (s *S3CompatibleStorage) ListTopLevelFolders(ctx context.Context, bucket string) ([]string, error) {
-	client, err := minio.New(s.Endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(s.AccessKey, s.SecretKey, ""),
-		Secure: s.UseSSL,
-		Region: s.Region,
-	})
+	client, err := s.client()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create S3-compatible client: %w", err)
+		return nil, err
 	}
 
 	objectCh := client.ListObjects(ctx, bucket, minio.ListObjectsOptions{
@@ -196,13 +302,9 @@ func (s *S3CompatibleStorage) ListTopLevelFolders(ctx context.Context, bucket st
 
 // GetLatestObjectKeyForUser finds the most recent object for a given user.
 func (s *S3CompatibleStorage) GetLatestObjectKeyForUser(ctx context.Context, bucket, username string) (string, error) {
-	client, err := minio.New(s.Endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(s.AccessKey, s.SecretKey, ""),
-		Secure: s.UseSSL,
-		Region: s.Region,
-	})
+	client, err := s.client()
 	if err != nil {
-		return "", fmt.Errorf("failed to create S3-compatible client: %w", err)
+		return "", err
 	}
 
 	prefix := username + "/"
@@ -241,13 +343,9 @@ func (s *S3CompatibleStorage) GetLatestObjectKeyForUser(ctx context.Context, buc
 
 // Copy performs a server-side copy of an object.
 func (s *S3CompatibleStorage) Copy(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string) error {
-	client, err := minio.New(s.Endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(s.AccessKey, s.SecretKey, ""),
-		Secure: s.UseSSL,
-		Region: s.Region,
-	})
+	client, err := s.client()
 	if err != nil {
-		return fmt.Errorf("failed to create S3-compatible client: %w", err)
+		return err
 	}
 
 	srcOpts := minio.CopySrcOptions{
@@ -268,42 +366,24 @@ func (s *S3CompatibleStorage) Copy(ctx context.Context, srcBucket, srcKey, dstBu
 
 // Delete removes all objects with a given prefix from a bucket.
 func (s *S3CompatibleStorage) Delete(ctx context.Context, bucket, prefix string) error {
-	client, err := minio.New(s.Endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(s.AccessKey, s.SecretKey, ""),
-		Secure: s.UseSSL,
-		Region: s.Region,
-	})
+	client, err := s.client()
 	if err != nil {
-		return fmt.Errorf("failed to create S3-compatible client: %w", err)
+		return err
 	}
 
-	// List all objects with the prefix
-	objectCh := client.ListObjects(ctx, bucket, minio.ListObjectsOptions{
-		Prefix:    prefix,
-		Recursive: true,
-	})
-
-	// Create a channel for objects to delete
-	objectsCh := make(chan minio.ObjectInfo)
-
-	go func() {
-		defer close(objectsCh)
-		for object := range objectCh {
-			if object.Err != nil {
-				continue
-			}
-			objectsCh <- object
-		}
-	}()
+	return removePrefix(ctx, client, bucket, prefix)
+}
 
-	// Remove objects
-	errorCh := client.RemoveObjects(ctx, bucket, objectsCh, minio.RemoveObjectsOptions{})
-	for err := range errorCh {
-		if err.Err != nil {
-			return fmt.Errorf("failed to delete object %s: %w", err.ObjectName, err.Err)
-		}
+// DeleteObject removes the object at key.
+func (s *S3CompatibleStorage) DeleteObject(ctx context.Context, bucket, key string) error {
+	client, err := s.client()
+	if err != nil {
+		return err
 	}
 
+	if err := client.RemoveObject(ctx, bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to delete object %s: %w", key, err)
+	}
 	return nil
 }
 
@@ -325,13 +405,9 @@ func (s *S3CompatibleStorage) GetURL(bucket, key string) string {
 
 // GetPresignedURL generates a presigned URL for accessing an object
 func (s *S3CompatibleStorage) GetPresignedURL(ctx context.Context, bucket, key string, expires time.Duration) (string, error) {
-	client, err := minio.New(s.Endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(s.AccessKey, s.SecretKey, ""),
-		Secure: s.UseSSL,
-		Region: s.Region,
-	})
+	client, err := s.client()
 	if err != nil {
-		return "", fmt.Errorf("failed to create S3-compatible client: %w", err)
+		return "", err
 	}
 
 	presignedURL, err := client.PresignedGetObject(ctx, bucket, key, expires, nil)
@@ -344,15 +420,14 @@ func (s *S3CompatibleStorage) GetPresignedURL(ctx context.Context, bucket, key s
 
 // SetupBucketPublicRead sets the bucket policy to allow public read access
 func (s *S3CompatibleStorage) SetupBucketPublicRead(ctx context.Context, bucket string) error {
-	client, err := minio.New(s.Endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(s.AccessKey, s.SecretKey, ""),
-		Secure: s.UseSSL,
-		Region: s.Region,
-	})
+	client, err := s.client()
 	if err != nil {
-		return fmt.Errorf("failed to create S3-compatible client: %w", err)
+		return err
 	}
+	return s.setBucketPublicRead(ctx, client, bucket)
+}
 
+func (s *S3CompatibleStorage) setBucketPublicRead(ctx context.Context, client *minio.Client, bucket string) error {
 	policy := fmt.Sprintf(`{
 		"Version": "2012-10-17",
 		"Statement": [
@@ -365,7 +440,7 @@ func (s *S3CompatibleStorage) SetupBucketPublicRead(ctx context.Context, bucket
 		]
 	}`, bucket)
 
-	err = client.SetBucketPolicy(ctx, bucket, policy)
+	err := client.SetBucketPolicy(ctx, bucket, policy)
 	if err != nil {
 		return fmt.Errorf("failed to set bucket policy: %w", err)
 	}
@@ -373,81 +448,350 @@ func (s *S3CompatibleStorage) SetupBucketPublicRead(ctx context.Context, bucket
 	return nil
 }
 
-// S3Storage implements ObjectStorage using AWS S3
+// S3Storage implements ObjectStorage using AWS S3. It is safe for concurrent use.
 type S3Storage struct {
 	Region    string
+	Endpoint  string
 	AccessKey string
 	SecretKey string
+	UseSSL    bool
+
+	clientOnce sync.Once
+	minio      *minio.Client
+	clientErr  error
+	buckets    sync.Map // bucket name -> struct{}, buckets known to exist
 }
 
-// NewS3Storage creates a new S3 storage instance
+// NewS3Storage creates a new S3 storage instance. AWS_S3_ENDPOINT may be set to
+// point the client at another S3 API (e.g. a local Minio); it defaults to AWS.
 func NewS3Storage(cfg *Config) *S3Storage {
+	endpoint := cfg.AWSS3Endpoint
+	useSSL := true
+	switch {
+	case endpoint == "":
+		endpoint = "s3.amazonaws.com"
+	case strings.HasPrefix(endpoint, "http://"):
+		endpoint = strings.TrimPrefix(endpoint, "http://")
+		useSSL = false
+	case strings.HasPrefix(endpoint, "https://"):
+		endpoint = strings.TrimPrefix(endpoint, "https://")
+	}
 	return &S3Storage{
 		Region:    cfg.AWSRegion,
+		Endpoint:  endpoint,
 		AccessKey: cfg.AWSAccessKey,
 		SecretKey: cfg.AWSSecretKey,
+		UseSSL:    useSSL,
 	}
 }
 
+// isAWS reports whether the storage talks to AWS itself rather than an override endpoint.
+func (s *S3Storage) isAWS() bool {
+	return strings.HasSuffix(s.Endpoint, "amazonaws.com")
+}
+
+// client returns the shared S3 client, creating it on first use.
+func (s *S3Storage) client() (*minio.Client, error) {
+	s.clientOnce.Do(func() {
+		s.minio, s.clientErr = s.newClient()
+	})
+	return s.minio, s.clientErr
+}
+
+func (s *S3Storage) newClient() (*minio.Client, error) {
+	var creds *credentials.Credentials
+	if s.AccessKey != "" {
+		creds = credentials.NewStaticV4(s.AccessKey, s.SecretKey, "")
+	} else {
+		// Fall back to the standard AWS credential chain (env, shared file, IAM role)
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{},
+		})
+	}
+	bucketLookup := minio.BucketLookupDNS
+	if !s.isAWS() {
+		bucketLookup = minio.BucketLookupPath
+	}
+	client, err := minio.New(s.Endpoint, &minio.Options{
+		Creds:        creds,
+		Secure:       s.UseSSL,
+		Region:       s.Region,
+		BucketLookup: bucketLookup,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+	return client, nil
+}
+
+// ensureBucket creates the bucket if it doesn't exist. Unlike Minio we don't attach a
+// public read policy here; on AWS that is governed by the account's Block Public Access settings.
+func (s *S3Storage) ensureBucket(ctx context.Context, client *minio.Client, bucket string) error {
+	if _, ok := s.buckets.Load(bucket); ok {
+		return nil
+	}
+
+	exists, err := client.BucketExists(ctx, bucket)
+	if err != nil {
+		return fmt.Errorf("failed to check bucket existence: %w", err)
+	}
+	if !exists {
+		err = client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{Region: s.Region})
+		if err != nil {
+			return fmt.Errorf("failed to create bucket: %w", err)
+		}
+	}
+
+	s.buckets.Store(bucket, struct{}{})
+	return nil
+}
+
 // Store stores content in S3 and returns the URL
 func (s *S3Storage) Store(ctx context.Context, data []byte, bucket, key, contentType string) (string, error) {
-	// In a real implementation, you would use the AWS SDK
-	// For now, return a mock S3 URL
-	url := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, s.Region, key)
-	return url, nil
+	return s.Put(ctx, bucket, key, bytes.NewReader(data), int64(len(data)), contentType, nil)
+}
+
+// Put streams content into S3 and returns the URL
+func (s *S3Storage) Put(ctx context.Context, bucket, key string, r io.Reader, size int64, contentType string, metadata map[string]string) (string, error) {
+	client, err := s.client()
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.ensureBucket(ctx, client, bucket); err != nil {
+		return "", err
+	}
+
+	_, err = client.PutObject(ctx, bucket, key, r, size, minio.PutObjectOptions{
+		ContentType:  contentType,
+		UserMetadata: metadata,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload to S3: %w", err)
+	}
+
+	return s.GetURL(bucket, key), nil
+}
+
+// Get opens an object in S3 for reading.
+func (s *S3Storage) Get(ctx context.Context, bucket, key string) (io.ReadCloser, ObjectInfo, error) {
+	client, err := s.client()
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	return getMinioObject(ctx, client, bucket, key)
+}
+
+// Stat checks if an object exists and returns its public URL if it does.
+func (s *S3Storage) Stat(ctx context.Context, bucket, key string) (string, error) {
+	if _, err := s.StatInfo(ctx, bucket, key); err != nil {
+		return "", err
+	}
+	return s.GetURL(bucket, key), nil
+}
+
+// StatInfo returns an object's attributes and user metadata.
+func (s *S3Storage) StatInfo(ctx context.Context, bucket, key string) (ObjectInfo, error) {
+	client, err := s.client()
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	info, err := client.StatObject(ctx, bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("object %s not found in bucket %s: %w", key, bucket, err)
+	}
+	return minioObjectInfo(info), nil
 }
 
-// List for S3 (mock implementation)
+// List lists objects in an S3 bucket with a given prefix.
 func (s *S3Storage) List(ctx context.Context, bucket, prefix string) ([]string, error) {
-	// Mock implementation for AWS S3
-	return []string{
-		prefix + "user1.png",
-		prefix + "user2.png",
-	}, nil
+	client, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []string
+	for object := range client.ListObjects(ctx, bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("failed during object listing: %w", object.Err)
+		}
+		objects = append(objects, object.Key)
+	}
+	return objects, nil
 }
 
-// ListTopLevelFolders for S3 (mock implementation)
+// ListTopLevelFolders lists "directories" at the root of a bucket.
 func (s *S3Storage) ListTopLevelFolders(ctx context.Context, bucket string) ([]string, error) {
-	// Mock implementation for AWS S3
-	return []string{"user1", "user2", "user3"}, nil
+	client, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+
+	folders := make(map[string]struct{})
+	for object := range client.ListObjects(ctx, bucket, minio.ListObjectsOptions{Recursive: false}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("failed during object listing: %w", object.Err)
+		}
+		folders[strings.SplitN(strings.TrimSuffix(object.Key, "/"), "/", 2)[0]] = struct{}{}
+	}
+
+	var folderList []string
+	for folder := range folders {
+		folderList = append(folderList, folder)
+	}
+	return folderList, nil
 }
 
-// GetLatestObjectKeyForUser for S3 (mock implementation)
+// GetLatestObjectKeyForUser finds the most recent object for a given user.
 func (s *S3Storage) GetLatestObjectKeyForUser(ctx context.Context, bucket, username string) (string, error) {
-	return fmt.Sprintf("%s/1234567890/content.png", username), nil
+	client, err := s.client()
+	if err != nil {
+		return "", err
+	}
+
+	prefix := username + "/"
+	var latestKey string
+	var latestModified time.Time
+	for object := range client.ListObjects(ctx, bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return "", fmt.Errorf("failed during object listing: %w", object.Err)
+		}
+		// Only consider keys shaped like username/timestamp/content.ext
+		if len(strings.Split(strings.TrimPrefix(object.Key, prefix), "/")) < 2 {
+			continue
+		}
+		if object.LastModified.After(latestModified) {
+			latestModified = object.LastModified
+			latestKey = object.Key
+		}
+	}
+
+	if latestKey == "" {
+		return "", fmt.Errorf("no objects found for user: %s", username)
+	}
+	return latestKey, nil
 }
 
-// Copy for S3 (mock implementation)
+// Copy performs a server-side copy of an object.
 func (s *S3Storage) Copy(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string) error {
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: dstBucket, Object: dstKey},
+		minio.CopySrcOptions{Bucket: srcBucket, Object: srcKey},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to copy object: %w", err)
+	}
 	return nil
 }
 
-// Delete for S3 (mock implementation)
+// Delete removes all objects with a given prefix from a bucket.
 func (s *S3Storage) Delete(ctx context.Context, bucket, prefix string) error {
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+
+	return removePrefix(ctx, client, bucket, prefix)
+}
+
+// DeleteObject removes the object at key.
+func (s *S3Storage) DeleteObject(ctx context.Context, bucket, key string) error {
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+
+	if err := client.RemoveObject(ctx, bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to delete object %s: %w", key, err)
+	}
 	return nil
 }
 
+// removePrefix deletes every object under prefix. A listing error stops the deletion
+// and is returned. A failed removal cancels the listing, and the removal results are
+// drained either way, so no goroutine outlives the call.
+func removePrefix(ctx context.Context, client *minio.Client, bucket, prefix string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	listErr := make(chan error, 1)
+	objectsCh := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objectsCh)
+		for object := range client.ListObjects(ctx, bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+			if object.Err != nil {
+				listErr <- object.Err
+				return
+			}
+			select {
+			case objectsCh <- object:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var rmErr error
+	for result := range client.RemoveObjects(ctx, bucket, objectsCh, minio.RemoveObjectsOptions{}) {
+		if result.Err != nil && rmErr == nil {
+			rmErr = fmt.Errorf("failed to delete object %s: %w", result.ObjectName, result.Err)
+			cancel()
+		}
+	}
+	if rmErr != nil {
+		return rmErr // the listing may have failed too, but only because it was cancelled
+	}
+	select {
+	case err := <-listErr:
+		return fmt.Errorf("failed during object listing: %w", err)
+	default:
+		return nil
+	}
+}
+
 // GetURL returns the URL for a stored object
 func (s *S3Storage) GetURL(bucket, key string) string {
-	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, s.Region, key)
+	if s.isAWS() {
+		return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, s.Region, key)
+	}
+	protocol := "http"
+	if s.UseSSL {
+		protocol = "https"
+	}
+	return fmt.Sprintf("%s://%s/%s/%s", protocol, s.Endpoint, bucket, key)
 }
 
-// GetPresignedURL for S3 (mock implementation)
+// GetPresignedURL generates a presigned URL for accessing an object
 func (s *S3Storage) GetPresignedURL(ctx context.Context, bucket, key string, expires time.Duration) (string, error) {
-	// Mock implementation - in real AWS S3, you'd use aws-sdk-go v2 to generate presigned URLs
-	return s.GetURL(bucket, key), nil
-}
+	client, err := s.client()
+	if err != nil {
+		return "", err
+	}
 
-// Stat for S3 (mock implementation)
-func (s *S3Storage) Stat(ctx context.Context, bucket, key string) (string, error) {
-	// Mock implementation for AWS S3
-	return s.GetURL(bucket, key), nil
+	presignedURL, err := client.PresignedGetObject(ctx, bucket, key, expires, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate presigned URL: %w", err)
+	}
+	return presignedURL.String(), nil
 }
 
-// GCSStorage implements ObjectStorage using Google Cloud Storage
+// GCSStorage implements ObjectStorage using Google Cloud Storage. The client honors
+// STORAGE_EMULATOR_HOST, so it can also be pointed at a fake GCS server.
 type GCSStorage struct {
 	ProjectID       string
 	CredentialsPath string
+
+	clientOnce sync.Once
+	gcs        *gcs.Client
+	clientErr  error
 }
 
 // NewGCSStorage creates a new GCS storage instance
@@ -458,68 +802,288 @@ func NewGCSStorage(cfg *Config) *GCSStorage {
 	}
 }
 
+// client returns the shared GCS client, creating it on first use. The client
+// outlives any single request, so it isn't bound to the caller's context.
+func (g *GCSStorage) client(ctx context.Context) (*gcs.Client, error) {
+	g.clientOnce.Do(func() {
+		var opts []option.ClientOption
+		if g.CredentialsPath != "" {
+			opts = append(opts, option.WithCredentialsFile(g.CredentialsPath))
+		}
+		g.gcs, g.clientErr = gcs.NewClient(context.WithoutCancel(ctx), opts...)
+		if g.clientErr != nil {
+			g.clientErr = fmt.Errorf("failed to create GCS client: %w", g.clientErr)
+		}
+	})
+	return g.gcs, g.clientErr
+}
+
 // Store stores content in GCS and returns the URL
 func (g *GCSStorage) Store(ctx context.Context, data []byte, bucket, key, contentType string) (string, error) {
-	// In a real implementation, you would use the GCS client
-	// For now, return a mock GCS URL
-	url := fmt.Sprintf("https://storage.googleapis.com/%s/%s", bucket, key)
-	return url, nil
+	return g.Put(ctx, bucket, key, bytes.NewReader(data), int64(len(data)), contentType, nil)
+}
+
+// Put streams content into GCS and returns the URL
+func (g *GCSStorage) Put(ctx context.Context, bucket, key string, r io.Reader, size int64, contentType string, metadata map[string]string) (string, error) {
+	client, err := g.client(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// Cancelling the writer's context is the only way to abandon a GCS upload; closing it
+	// would finalize whatever was written so far
+	uploadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := client.Bucket(bucket).Object(key).NewWriter(uploadCtx)
+	w.ContentType = contentType
+	w.Metadata = metadata
+	if _, err := io.Copy(w, r); err != nil {
+		cancel()
+		w.Close()
+		return "", fmt.Errorf("failed to upload to GCS: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("failed to upload to GCS: %w", err)
+	}
+
+	return g.GetURL(bucket, key), nil
+}
+
+// Get opens an object in GCS for reading.
+func (g *GCSStorage) Get(ctx context.Context, bucket, key string) (io.ReadCloser, ObjectInfo, error) {
+	client, err := g.client(ctx)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	obj := client.Bucket(bucket).Object(key)
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		return nil, ObjectInfo{}, fmt.Errorf("object %s not found in bucket %s: %w", key, bucket, err)
+	}
+	// Pin the generation so the reader matches the attributes we return
+	reader, err := obj.Generation(attrs.Generation).NewReader(ctx)
+	if err != nil {
+		return nil, ObjectInfo{}, fmt.Errorf("failed to get object %s from bucket %s: %w", key, bucket, err)
+	}
+	return reader, gcsObjectInfo(attrs), nil
+}
+
+// gcsObjectInfo converts GCS object attributes to an ObjectInfo.
+func gcsObjectInfo(attrs *gcs.ObjectAttrs) ObjectInfo {
+	return ObjectInfo{
+		Key:          attrs.Name,
+		Size:         attrs.Size,
+		ContentType:  attrs.ContentType,
+		LastModified: attrs.Updated,
+		ETag:         attrs.Etag,
+		Metadata:     normalizeMetadata(attrs.Metadata),
+	}
 }
 
-// List for GCS (mock implementation)
+// Stat checks if an object exists and returns its public URL if it does.
+func (g *GCSStorage) Stat(ctx context.Context, bucket, key string) (string, error) {
+	if _, err := g.StatInfo(ctx, bucket, key); err != nil {
+		return "", err
+	}
+	return g.GetURL(bucket, key), nil
+}
+
+// StatInfo returns an object's attributes and user metadata.
+func (g *GCSStorage) StatInfo(ctx context.Context, bucket, key string) (ObjectInfo, error) {
+	client, err := g.client(ctx)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	attrs, err := client.Bucket(bucket).Object(key).Attrs(ctx)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("object %s not found in bucket %s: %w", key, bucket, err)
+	}
+	return gcsObjectInfo(attrs), nil
+}
+
+// List lists objects in a GCS bucket with a given prefix.
 func (g *GCSStorage) List(ctx context.Context, bucket, prefix string) ([]string, error) {
-	// Mock implementation for GCS
-	return []string{
-		prefix + "user1.png",
-		prefix + "user2.png",
-	}, nil
+	client, err := g.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []string
+	it := client.Bucket(bucket).Objects(ctx, &gcs.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed during object listing: %w", err)
+		}
+		objects = append(objects, attrs.Name)
+	}
+	return objects, nil
 }
 
-// ListTopLevelFolders for GCS (mock implementation)
+// ListTopLevelFolders lists "directories" at the root of a bucket.
 func (g *GCSStorage) ListTopLevelFolders(ctx context.Context, bucket string) ([]string, error) {
-	// Mock implementation for GCS
-	return []string{"user1", "user2", "user3"}, nil
+	client, err := g.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	folders := make(map[string]struct{})
+	it := client.Bucket(bucket).Objects(ctx, &gcs.Query{Delimiter: "/"})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed during object listing: %w", err)
+		}
+		// With a delimiter, "folders" come back as synthetic entries with only Prefix set
+		name := attrs.Prefix
+		if name == "" {
+			name = attrs.Name
+		}
+		folders[strings.SplitN(strings.TrimSuffix(name, "/"), "/", 2)[0]] = struct{}{}
+	}
+
+	var folderList []string
+	for folder := range folders {
+		folderList = append(folderList, folder)
+	}
+	return folderList, nil
 }
 
-// GetLatestObjectKeyForUser for GCS (mock implementation)
+// GetLatestObjectKeyForUser finds the most recent object for a given user.
 func (g *GCSStorage) GetLatestObjectKeyForUser(ctx context.Context, bucket, username string) (string, error) {
-	return fmt.Sprintf("%s/1234567890/content.png", username), nil
+	client, err := g.client(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	prefix := username + "/"
+	var latestKey string
+	var latestModified time.Time
+	it := client.Bucket(bucket).Objects(ctx, &gcs.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed during object listing: %w", err)
+		}
+		// Only consider keys shaped like username/timestamp/content.ext
+		if len(strings.Split(strings.TrimPrefix(attrs.Name, prefix), "/")) < 2 {
+			continue
+		}
+		if attrs.Updated.After(latestModified) {
+			latestModified = attrs.Updated
+			latestKey = attrs.Name
+		}
+	}
+
+	if latestKey == "" {
+		return "", fmt.Errorf("no objects found for user: %s", username)
+	}
+	return latestKey, nil
 }
 
-// Copy for GCS (mock implementation)
+// Copy performs a server-side copy of an object.
 func (g *GCSStorage) Copy(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string) error {
+	client, err := g.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	src := client.Bucket(srcBucket).Object(srcKey)
+	if _, err := client.Bucket(dstBucket).Object(dstKey).CopierFrom(src).Run(ctx); err != nil {
+		return fmt.Errorf("failed to copy object: %w", err)
+	}
 	return nil
 }
 
-// Delete for GCS (mock implementation)
+// Delete removes all objects with a given prefix from a bucket.
 func (g *GCSStorage) Delete(ctx context.Context, bucket, prefix string) error {
+	client, err := g.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	bkt := client.Bucket(bucket)
+	it := bkt.Objects(ctx, &gcs.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed during object listing: %w", err)
+		}
+		if err := bkt.Object(attrs.Name).Delete(ctx); err != nil && !errors.Is(err, gcs.ErrObjectNotExist) {
+			return fmt.Errorf("failed to delete object %s: %w", attrs.Name, err)
+		}
+	}
+	return nil
+}
+
+// DeleteObject removes the object at key.
+func (g *GCSStorage) DeleteObject(ctx context.Context, bucket, key string) error {
+	client, err := g.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := client.Bucket(bucket).Object(key).Delete(ctx); err != nil && !errors.Is(err, gcs.ErrObjectNotExist) {
+		return fmt.Errorf("failed to delete object %s: %w", key, err)
+	}
 	return nil
 }
 
 // GetURL returns the URL for a stored object
 func (g *GCSStorage) GetURL(bucket, key string) string {
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		return fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(host, "/"), bucket, key)
+	}
 	return fmt.Sprintf("https://storage.googleapis.com/%s/%s", bucket, key)
 }
 
-// GetPresignedURL for GCS (mock implementation)
+// GetPresignedURL generates a V4 signed URL for accessing an object. Signing uses the
+// service account from GOOGLE_APPLICATION_CREDENTIALS or the runtime's IAM credentials.
 func (g *GCSStorage) GetPresignedURL(ctx context.Context, bucket, key string, expires time.Duration) (string, error) {
-	// Mock implementation - in real GCS, you'd use cloud.google.com/go/storage to generate signed URLs
-	return g.GetURL(bucket, key), nil
-}
+	client, err := g.client(ctx)
+	if err != nil {
+		return "", err
+	}
 
-// Stat for GCS (mock implementation)
-func (g *GCSStorage) Stat(ctx context.Context, bucket, key string) (string, error) {
-	// Mock implementation for GCS
-	return g.GetURL(bucket, key), nil
+	signedURL, err := client.Bucket(bucket).SignedURL(key, &gcs.SignedURLOptions{
+		Method:  http.MethodGet,
+		Expires: time.Now().Add(expires),
+		Scheme:  gcs.SigningSchemeV4,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to generate signed URL: %w", err)
+	}
+	return signedURL, nil
 }
 
-// NewObjectStorage creates a new ObjectStorage instance based on the provider
+// NewObjectStorage creates a new ObjectStorage instance based on the provider.
+// The returned value is safe for concurrent use and is meant to be created once
+// at startup and shared (see appStorage) rather than rebuilt per call.
 func NewObjectStorage(cfg *Config) ObjectStorage {
 	switch strings.ToLower(cfg.StorageProvider) {
 	case "aws-s3":
 		return NewS3Storage(cfg)
 	case "gcs":
 		return NewGCSStorage(cfg)
+	case "fs":
+		return NewFSStorage(cfg)
 	case "s3":
 		fallthrough
 	case "minio":
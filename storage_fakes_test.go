@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeObject is one object held by a fake storage server.
+type fakeObject struct {
+	data        []byte
+	contentType string
+	metadata    map[string]string
+	modified    time.Time
+	generation  int64
+}
+
+func (o *fakeObject) etag() string {
+	sum := md5.Sum(o.data)
+	return hex.EncodeToString(sum[:])
+}
+
+// fakeObjectStore is the in-memory state behind the fake S3 and GCS servers. It
+// implements just enough of each API for the ObjectStorage backends.
+type fakeObjectStore struct {
+	mu         sync.Mutex
+	buckets    map[string]map[string]*fakeObject
+	generation int64
+}
+
+func newFakeObjectStore() *fakeObjectStore {
+	return &fakeObjectStore{buckets: make(map[string]map[string]*fakeObject)}
+}
+
+func (s *fakeObjectStore) createBucket(bucket string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = make(map[string]*fakeObject)
+	}
+}
+
+func (s *fakeObjectStore) bucketExists(bucket string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buckets[bucket] != nil
+}
+
+func (s *fakeObjectStore) put(bucket, key string, obj fakeObject) (*fakeObject, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objects := s.buckets[bucket]
+	if objects == nil {
+		return nil, false
+	}
+	s.generation++
+	obj.generation = s.generation
+	obj.modified = time.Now().UTC().Truncate(time.Second)
+	objects[key] = &obj
+	return &obj, true
+}
+
+func (s *fakeObjectStore) get(bucket, key string) (*fakeObject, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.buckets[bucket][key]
+	return obj, ok
+}
+
+func (s *fakeObjectStore) delete(bucket, key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.buckets[bucket][key]
+	delete(s.buckets[bucket], key)
+	return ok
+}
+
+// list returns the keys under prefix in order. With a delimiter, keys that continue past
+// it are rolled up into common prefixes.
+func (s *fakeObjectStore) list(bucket, prefix, delimiter string) (keys, prefixes []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := make(map[string]bool)
+	for key := range s.buckets[bucket] {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				p := key[:len(prefix)+i+len(delimiter)]
+				if !seen[p] {
+					seen[p] = true
+					prefixes = append(prefixes, p)
+				}
+				continue
+			}
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	sort.Strings(prefixes)
+	return keys, prefixes
+}
+
+// newFakeS3 starts a path-style S3 server backed by an in-memory store.
+func newFakeS3(t *testing.T) *httptest.Server {
+	t.Helper()
+	store := newFakeObjectStore()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		q := r.URL.Query()
+		switch {
+		case key == "" && r.Method == http.MethodHead:
+			if !store.bucketExists(bucket) {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case key == "" && r.Method == http.MethodPut && q.Has("policy"):
+			w.WriteHeader(http.StatusNoContent)
+		case key == "" && r.Method == http.MethodPut:
+			store.createBucket(bucket)
+		case key == "" && r.Method == http.MethodGet && q.Get("list-type") == "2":
+			fakeS3List(w, store, bucket, q.Get("prefix"), q.Get("delimiter"))
+		case key == "" && r.Method == http.MethodPost && q.Has("delete"):
+			fakeS3DeleteObjects(w, r, store, bucket)
+		case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+			fakeS3Copy(w, r, store, bucket, key)
+		case r.Method == http.MethodPut:
+			fakeS3Put(w, r, store, bucket, key)
+		case r.Method == http.MethodDelete:
+			store.delete(bucket, key)
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodGet || r.Method == http.MethodHead:
+			obj, ok := store.get(bucket, key)
+			if !ok {
+				writeFakeS3Error(w, r, http.StatusNotFound, "NoSuchKey")
+				return
+			}
+			h := w.Header()
+			h.Set("Content-Type", obj.contentType)
+			h.Set("Content-Length", strconv.Itoa(len(obj.data)))
+			h.Set("ETag", `"`+obj.etag()+`"`)
+			h.Set("Last-Modified", obj.modified.Format(http.TimeFormat))
+			for k, v := range obj.metadata {
+				h.Set("X-Amz-Meta-"+k, v)
+			}
+			if r.Method == http.MethodGet {
+				w.Write(obj.data)
+			}
+		default:
+			writeFakeS3Error(w, r, http.StatusNotImplemented, "NotImplemented")
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func writeFakeS3Error(w http.ResponseWriter, r *http.Request, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+	}
+}
+
+func fakeS3Put(w http.ResponseWriter, r *http.Request, store *fakeObjectStore, bucket, key string) {
+	var data []byte
+	var err error
+	// Over plain HTTP minio signs the body in chunks
+	if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		data, err = decodeAWSChunked(r.Body)
+	} else {
+		data, err = io.ReadAll(r.Body)
+	}
+	if err != nil {
+		writeFakeS3Error(w, r, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+	metadata := make(map[string]string)
+	for k, v := range r.Header {
+		if name, ok := strings.CutPrefix(k, "X-Amz-Meta-"); ok {
+			metadata[strings.ToLower(name)] = v[0]
+		}
+	}
+	obj, ok := store.put(bucket, key, fakeObject{data: data, contentType: r.Header.Get("Content-Type"), metadata: metadata})
+	if !ok {
+		writeFakeS3Error(w, r, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	w.Header().Set("ETag", `"`+obj.etag()+`"`)
+}
+
+// decodeAWSChunked strips the chunk framing of a streaming-signed upload.
+func decodeAWSChunked(r io.Reader) ([]byte, error) {
+	br := bufio.NewReader(r)
+	var data []byte
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return data, nil
+		}
+		chunk := make([]byte, size)
+		if _, err := io.ReadFull(br, chunk); err != nil {
+			return nil, err
+		}
+		data = append(data, chunk...)
+		if _, err := br.Discard(2); err != nil { // CRLF
+			return nil, err
+		}
+	}
+}
+
+func fakeS3Copy(w http.ResponseWriter, r *http.Request, store *fakeObjectStore, bucket, key string) {
+	source, err := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+	if err != nil {
+		writeFakeS3Error(w, r, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+	srcBucket, srcKey, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	src, ok := store.get(srcBucket, srcKey)
+	if !ok {
+		writeFakeS3Error(w, r, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	obj, ok := store.put(bucket, key, fakeObject{data: src.data, contentType: src.contentType, metadata: src.metadata})
+	if !ok {
+		writeFakeS3Error(w, r, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><CopyObjectResult><LastModified>%s</LastModified><ETag>"%s"</ETag></CopyObjectResult>`,
+		obj.modified.Format(time.RFC3339), obj.etag())
+}
+
+type fakeS3ListResult struct {
+	XMLName        xml.Name `xml:"ListBucketResult"`
+	Name           string
+	Prefix         string
+	Delimiter      string `xml:",omitempty"`
+	KeyCount       int
+	MaxKeys        int
+	IsTruncated    bool
+	Contents       []fakeS3ListEntry
+	CommonPrefixes []fakeS3CommonPrefix
+}
+
+type fakeS3ListEntry struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int
+	StorageClass string
+}
+
+type fakeS3CommonPrefix struct {
+	Prefix string
+}
+
+func fakeS3List(w http.ResponseWriter, store *fakeObjectStore, bucket, prefix, delimiter string) {
+	keys, prefixes := store.list(bucket, prefix, delimiter)
+	result := fakeS3ListResult{Name: bucket, Prefix: prefix, Delimiter: delimiter, KeyCount: len(keys) + len(prefixes), MaxKeys: 1000}
+	for _, key := range keys {
+		obj, _ := store.get(bucket, key)
+		result.Contents = append(result.Contents, fakeS3ListEntry{
+			Key:          key,
+			LastModified: obj.modified.Format(time.RFC3339),
+			ETag:         `"` + obj.etag() + `"`,
+			Size:         len(obj.data),
+			StorageClass: "STANDARD",
+		})
+	}
+	for _, p := range prefixes {
+		result.CommonPrefixes = append(result.CommonPrefixes, fakeS3CommonPrefix{Prefix: p})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func fakeS3DeleteObjects(w http.ResponseWriter, r *http.Request, store *fakeObjectStore, bucket string) {
+	var req struct {
+		Objects []struct {
+			Key string
+		} `xml:"Object"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeFakeS3Error(w, r, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+	for _, obj := range req.Objects {
+		store.delete(bucket, obj.Key)
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><DeleteResult></DeleteResult>`)
+}
+
+// newFakeGCS starts a server speaking the parts of the GCS JSON API (and XML reads) the
+// storage client uses when STORAGE_EMULATOR_HOST points at it.
+func newFakeGCS(t *testing.T) *httptest.Server {
+	t.Helper()
+	store := newFakeObjectStore()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if path, ok := strings.CutPrefix(r.URL.EscapedPath(), "/upload/storage/v1/b/"); ok {
+			fakeGCSUpload(w, r, store, unescapeSegments(path)[0])
+			return
+		}
+		if path, ok := strings.CutPrefix(r.URL.EscapedPath(), "/storage/v1/b/"); ok {
+			fakeGCSJSON(w, r, store, unescapeSegments(path))
+			return
+		}
+
+		bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		obj, ok := store.get(bucket, key)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		h := w.Header()
+		h.Set("Content-Type", obj.contentType)
+		h.Set("Content-Length", strconv.Itoa(len(obj.data)))
+		h.Set("Last-Modified", obj.modified.Format(http.TimeFormat))
+		h.Set("X-Goog-Generation", strconv.FormatInt(obj.generation, 10))
+		h.Set("X-Goog-Metageneration", "1")
+		if r.Method == http.MethodGet {
+			w.Write(obj.data)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func unescapeSegments(path string) []string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if u, err := url.PathUnescape(s); err == nil {
+			segments[i] = u
+		}
+	}
+	return segments
+}
+
+func fakeGCSObject(bucket, name string, obj *fakeObject) map[string]any {
+	return map[string]any{
+		"kind":           "storage#object",
+		"bucket":         bucket,
+		"name":           name,
+		"size":           strconv.Itoa(len(obj.data)),
+		"contentType":    obj.contentType,
+		"metadata":       obj.metadata,
+		"generation":     strconv.FormatInt(obj.generation, 10),
+		"metageneration": "1",
+		"etag":           obj.etag(),
+		"timeCreated":    obj.modified.Format(time.RFC3339),
+		"updated":        obj.modified.Format(time.RFC3339),
+	}
+}
+
+func writeFakeGCSJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeFakeGCSNotFound(w http.ResponseWriter) {
+	writeFakeGCSJSON(w, http.StatusNotFound, map[string]any{"error": map[string]any{"code": 404, "message": "No such object"}})
+}
+
+// fakeGCSJSON serves b/{bucket}/o[/{object}[/rewriteTo/b/{bucket}/o/{object}]].
+func fakeGCSJSON(w http.ResponseWriter, r *http.Request, store *fakeObjectStore, segments []string) {
+	if len(segments) < 2 || segments[1] != "o" {
+		writeFakeGCSNotFound(w)
+		return
+	}
+	bucket := segments[0]
+	store.createBucket(bucket) // buckets exist up front in GCS
+	switch {
+	case len(segments) == 2 && r.Method == http.MethodGet:
+		q := r.URL.Query()
+		keys, prefixes := store.list(bucket, q.Get("prefix"), q.Get("delimiter"))
+		items := []map[string]any{}
+		for _, key := range keys {
+			obj, _ := store.get(bucket, key)
+			items = append(items, fakeGCSObject(bucket, key, obj))
+		}
+		writeFakeGCSJSON(w, http.StatusOK, map[string]any{"kind": "storage#objects", "items": items, "prefixes": prefixes})
+	case len(segments) == 3 && r.Method == http.MethodGet:
+		obj, ok := store.get(bucket, segments[2])
+		if !ok {
+			writeFakeGCSNotFound(w)
+			return
+		}
+		writeFakeGCSJSON(w, http.StatusOK, fakeGCSObject(bucket, segments[2], obj))
+	case len(segments) == 3 && r.Method == http.MethodDelete:
+		if !store.delete(bucket, segments[2]) {
+			writeFakeGCSNotFound(w)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case len(segments) == 8 && segments[3] == "rewriteTo" && r.Method == http.MethodPost:
+		src, ok := store.get(bucket, segments[2])
+		if !ok {
+			writeFakeGCSNotFound(w)
+			return
+		}
+		store.createBucket(segments[5])
+		obj, _ := store.put(segments[5], segments[7], fakeObject{data: src.data, contentType: src.contentType, metadata: src.metadata})
+		writeFakeGCSJSON(w, http.StatusOK, map[string]any{
+			"kind":                "storage#rewriteResponse",
+			"done":                true,
+			"totalBytesRewritten": strconv.Itoa(len(obj.data)),
+			"objectSize":          strconv.Itoa(len(obj.data)),
+			"resource":            fakeGCSObject(segments[5], segments[7], obj),
+		})
+	default:
+		writeFakeGCSJSON(w, http.StatusNotImplemented, map[string]any{"error": map[string]any{"code": 501, "message": "not implemented"}})
+	}
+}
+
+// fakeGCSUpload accepts multipart uploads, which the client uses for anything smaller
+// than one chunk.
+func fakeGCSUpload(w http.ResponseWriter, r *http.Request, store *fakeObjectStore, bucket string) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || r.URL.Query().Get("uploadType") != "multipart" {
+		writeFakeGCSJSON(w, http.StatusBadRequest, map[string]any{"error": map[string]any{"code": 400, "message": "expected a multipart upload"}})
+		return
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	var attrs struct {
+		Name        string            `json:"name"`
+		ContentType string            `json:"contentType"`
+		Metadata    map[string]string `json:"metadata"`
+	}
+	part, err := mr.NextPart()
+	if err == nil {
+		err = json.NewDecoder(part).Decode(&attrs)
+	}
+	var data []byte
+	if err == nil {
+		if part, err = mr.NextPart(); err == nil {
+			data, err = io.ReadAll(part)
+		}
+	}
+	if err != nil {
+		writeFakeGCSJSON(w, http.StatusBadRequest, map[string]any{"error": map[string]any{"code": 400, "message": err.Error()}})
+		return
+	}
+	if attrs.Name == "" {
+		attrs.Name = r.URL.Query().Get("name")
+	}
+	store.createBucket(bucket)
+	obj, _ := store.put(bucket, attrs.Name, fakeObject{data: data, contentType: attrs.ContentType, metadata: attrs.Metadata})
+	writeFakeGCSJSON(w, http.StatusOK, fakeGCSObject(bucket, attrs.Name, obj))
+}
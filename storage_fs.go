@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FSStorage implements ObjectStorage on a local directory. Buckets are
+// subdirectories of Root and keys are slash-separated paths beneath them.
+// Content type and user metadata live in a hidden ".<name>.meta.json" sidecar.
+// It is meant for offline development and tests; the API server serves the
+// files under /media/ so the URLs it hands out resolve.
+type FSStorage struct {
+	Root       string
+	PublicURL  string // base URL the /media/ route is reachable at
+	SigningKey string // if set, presigned URLs carry an HMAC signature
+}
+
+// NewFSStorage creates a new local filesystem storage instance
+func NewFSStorage(cfg *Config) *FSStorage {
+	return &FSStorage{
+		Root:       cfg.FSRoot,
+		PublicURL:  strings.TrimSuffix(cfg.FSPublicURL, "/"),
+		SigningKey: cfg.FSSigningKey,
+	}
+}
+
+// objectPath maps a bucket/key pair to a path under Root, rejecting keys that
+// would escape the bucket directory.
+func (f *FSStorage) objectPath(bucket, key string) (string, error) {
+	if bucket == "" || strings.ContainsAny(bucket, `/\`) || bucket == "." || bucket == ".." {
+		return "", fmt.Errorf("invalid bucket name: %q", bucket)
+	}
+	clean := path.Clean("/" + key)
+	if clean == "/" || clean != "/"+strings.TrimPrefix(key, "/") {
+		return "", fmt.Errorf("invalid object key: %q", key)
+	}
+	return filepath.Join(f.Root, bucket, filepath.FromSlash(clean)), nil
+}
+
+// fsObjectMeta is the sidecar record stored next to each object.
+type fsObjectMeta struct {
+	ContentType string            `json:"content_type"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// metaPath returns the sidecar path for the object at p.
+func metaPath(p string) string {
+	return filepath.Join(filepath.Dir(p), "."+filepath.Base(p)+".meta.json")
+}
+
+// readMeta loads the sidecar for the object at p, falling back to a content
+// type guessed from the extension for objects written without one.
+func readMeta(p string) fsObjectMeta {
+	var meta fsObjectMeta
+	if data, err := os.ReadFile(metaPath(p)); err == nil {
+		json.Unmarshal(data, &meta)
+	}
+	if meta.ContentType == "" {
+		meta.ContentType = mime.TypeByExtension(filepath.Ext(p))
+	}
+	return meta
+}
+
+// objectInfo builds an ObjectInfo for the object at p.
+func (f *FSStorage) objectInfo(key, p string, info fs.FileInfo) ObjectInfo {
+	meta := readMeta(p)
+	return ObjectInfo{
+		Key:          key,
+		Size:         info.Size(),
+		ContentType:  meta.ContentType,
+		LastModified: info.ModTime(),
+		ETag:         strconv.FormatInt(info.ModTime().UnixNano(), 36) + "-" + strconv.FormatInt(info.Size(), 36),
+		Metadata:     normalizeMetadata(meta.Metadata),
+	}
+}
+
+// walk calls fn for every regular file in bucket whose key starts with prefix.
+func (f *FSStorage) walk(bucket, prefix string, fn func(key string, info fs.FileInfo) error) error {
+	bucketDir := filepath.Join(f.Root, bucket)
+	err := filepath.WalkDir(bucketDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == bucketDir {
+				return fs.SkipAll
+			}
+			return err
+		}
+		// Skip directories as well as in-flight temp files and metadata sidecars
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(bucketDir, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(key, info)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to walk bucket %s: %w", bucket, err)
+	}
+	return nil
+}
+
+// writeFile writes data to p via a temp file and rename so readers never see partial objects.
+func writeFile(p string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+// Store writes content to the local filesystem and returns the URL
+func (f *FSStorage) Store(ctx context.Context, data []byte, bucket, key, contentType string) (string, error) {
+	return f.Put(ctx, bucket, key, bytes.NewReader(data), int64(len(data)), contentType, nil)
+}
+
+// Put streams content to the local filesystem and returns the URL
+func (f *FSStorage) Put(ctx context.Context, bucket, key string, r io.Reader, size int64, contentType string, metadata map[string]string) (string, error) {
+	p, err := f.objectPath(bucket, key)
+	if err != nil {
+		return "", err
+	}
+	if err := writeFile(p, r); err != nil {
+		return "", fmt.Errorf("failed to write object %s: %w", key, err)
+	}
+	meta, err := json.Marshal(fsObjectMeta{ContentType: contentType, Metadata: normalizeMetadata(metadata)})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode metadata for %s: %w", key, err)
+	}
+	if err := writeFile(metaPath(p), bytes.NewReader(meta)); err != nil {
+		return "", fmt.Errorf("failed to write metadata for %s: %w", key, err)
+	}
+	return f.GetURL(bucket, key), nil
+}
+
+// Get opens an object on the local filesystem for reading.
+func (f *FSStorage) Get(ctx context.Context, bucket, key string) (io.ReadCloser, ObjectInfo, error) {
+	p, err := f.objectPath(bucket, key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	file, err := os.Open(p)
+	if err != nil {
+		return nil, ObjectInfo{}, fmt.Errorf("object %s not found in bucket %s: %w", key, bucket, err)
+	}
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		file.Close()
+		return nil, ObjectInfo{}, fmt.Errorf("object %s not found in bucket %s", key, bucket)
+	}
+	return file, f.objectInfo(key, p, info), nil
+}
+
+// Stat checks if an object exists and returns its public URL if it does.
+func (f *FSStorage) Stat(ctx context.Context, bucket, key string) (string, error) {
+	if _, err := f.StatInfo(ctx, bucket, key); err != nil {
+		return "", err
+	}
+	return f.GetURL(bucket, key), nil
+}
+
+// StatInfo returns an object's attributes and user metadata.
+func (f *FSStorage) StatInfo(ctx context.Context, bucket, key string) (ObjectInfo, error) {
+	p, err := f.objectPath(bucket, key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("object %s not found in bucket %s: %w", key, bucket, err)
+	}
+	if info.IsDir() {
+		return ObjectInfo{}, fmt.Errorf("object %s not found in bucket %s: is a directory", key, bucket)
+	}
+	return f.objectInfo(key, p, info), nil
+}
+
+// List lists objects in a bucket with a given prefix.
+func (f *FSStorage) List(ctx context.Context, bucket, prefix string) ([]string, error) {
+	var objects []string
+	err := f.walk(bucket, prefix, func(key string, _ fs.FileInfo) error {
+		objects = append(objects, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// ListTopLevelFolders lists the directories (and any loose files) at the root of a bucket.
+func (f *FSStorage) ListTopLevelFolders(ctx context.Context, bucket string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(f.Root, bucket))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list bucket %s: %w", bucket, err)
+	}
+
+	var folders []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		folders = append(folders, entry.Name())
+	}
+	return folders, nil
+}
+
+// GetLatestObjectKeyForUser finds the most recently modified object for a given user.
+func (f *FSStorage) GetLatestObjectKeyForUser(ctx context.Context, bucket, username string) (string, error) {
+	prefix := username + "/"
+	var latestKey string
+	var latestModified time.Time
+	err := f.walk(bucket, prefix, func(key string, info fs.FileInfo) error {
+		// Only consider keys shaped like username/timestamp/content.ext
+		if len(strings.Split(strings.TrimPrefix(key, prefix), "/")) < 2 {
+			return nil
+		}
+		if info.ModTime().After(latestModified) {
+			latestModified = info.ModTime()
+			latestKey = key
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if latestKey == "" {
+		return "", fmt.Errorf("no objects found for user: %s", username)
+	}
+	return latestKey, nil
+}
+
+// Copy copies an object to a new location.
+func (f *FSStorage) Copy(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string) error {
+	src, err := f.objectPath(srcBucket, srcKey)
+	if err != nil {
+		return err
+	}
+	dst, err := f.objectPath(dstBucket, dstKey)
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to copy object: %w", err)
+	}
+	defer in.Close()
+
+	if err := writeFile(dst, in); err != nil {
+		return fmt.Errorf("failed to copy object: %w", err)
+	}
+	if meta, err := os.Open(metaPath(src)); err == nil {
+		defer meta.Close()
+		if err := writeFile(metaPath(dst), meta); err != nil {
+			return fmt.Errorf("failed to copy object metadata: %w", err)
+		}
+	}
+	return nil
+}
+
+// Delete removes all objects with a given prefix from a bucket, along with
+// any directories left empty.
+func (f *FSStorage) Delete(ctx context.Context, bucket, prefix string) error {
+	var keys []string
+	err := f.walk(bucket, prefix, func(key string, _ fs.FileInfo) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	bucketDir := filepath.Join(f.Root, bucket)
+	dirs := make(map[string]struct{})
+	for _, key := range keys {
+		p := filepath.Join(bucketDir, filepath.FromSlash(key))
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete object %s: %w", key, err)
+		}
+		os.Remove(metaPath(p))
+		for dir := filepath.Dir(p); dir != bucketDir && strings.HasPrefix(dir, bucketDir); dir = filepath.Dir(dir) {
+			dirs[dir] = struct{}{}
+		}
+	}
+
+	// Remove emptied directories deepest first; non-empty ones are left alone.
+	sorted := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sorted = append(sorted, dir)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	for _, dir := range sorted {
+		os.Remove(dir)
+	}
+	return nil
+}
+
+// DeleteObject removes the object at key and its sidecar, along with any
+// directories left empty.
+func (f *FSStorage) DeleteObject(ctx context.Context, bucket, key string) error {
+	p, err := f.objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete object %s: %w", key, err)
+	}
+	os.Remove(metaPath(p))
+
+	// Removing a non-empty directory fails, which ends the walk up
+	bucketDir := filepath.Join(f.Root, bucket)
+	for dir := filepath.Dir(p); dir != bucketDir && strings.HasPrefix(dir, bucketDir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// GetURL returns the URL the API server's /media/ route serves the object at
+func (f *FSStorage) GetURL(bucket, key string) string {
+	return f.PublicURL + "/" + bucket + "/" + strings.TrimPrefix(key, "/")
+}
+
+// GetPresignedURL returns a URL that expires after the given duration. Without a
+// signing key objects are public, so this is just the public URL.
+func (f *FSStorage) GetPresignedURL(ctx context.Context, bucket, key string, expires time.Duration) (string, error) {
+	if _, err := f.objectPath(bucket, key); err != nil {
+		return "", err
+	}
+	if f.SigningKey == "" {
+		return f.GetURL(bucket, key), nil
+	}
+	exp := strconv.FormatInt(time.Now().Add(expires).Unix(), 10)
+	q := url.Values{}
+	q.Set("expires", exp)
+	q.Set("signature", f.sign(bucket+"/"+strings.TrimPrefix(key, "/"), exp))
+	return f.GetURL(bucket, key) + "?" + q.Encode(), nil
+}
+
+func (f *FSStorage) sign(objectPath, expires string) string {
+	mac := hmac.New(sha256.New, []byte(f.SigningKey))
+	mac.Write([]byte(objectPath + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ServeHTTP serves objects at /{bucket}/{key}; mount it with http.StripPrefix.
+// With a signing key set, only requests carrying a valid, unexpired presigned
+// signature are served. Hidden files (metadata sidecars and in-progress
+// uploads) are never served.
+func (f *FSStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	objectPath := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, ok := strings.Cut(objectPath, "/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	for _, segment := range strings.Split(objectPath, "/") {
+		if strings.HasPrefix(segment, ".") {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	if f.SigningKey != "" {
+		sig := r.URL.Query().Get("signature")
+		exp := r.URL.Query().Get("expires")
+		expUnix, err := strconv.ParseInt(exp, 10, 64)
+		if sig == "" || err != nil || time.Now().Unix() > expUnix || !hmac.Equal([]byte(sig), []byte(f.sign(objectPath, exp))) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	p, err := f.objectPath(bucket, key)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	file, err := os.Open(p)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	if contentType := readMeta(p).ContentType; contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
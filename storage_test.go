@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testObjectStorage checks the behavior every ObjectStorage backend must share. Each
+// subtest works under its own prefix of bucket.
+func testObjectStorage(t *testing.T, store ObjectStorage, bucket string) {
+	ctx := context.Background()
+	put := func(t *testing.T, key, body, contentType string, metadata map[string]string) {
+		t.Helper()
+		if _, err := store.Put(ctx, bucket, key, strings.NewReader(body), int64(len(body)), contentType, metadata); err != nil {
+			t.Fatalf("Put(%s): %v", key, err)
+		}
+	}
+	list := func(t *testing.T, prefix string) []string {
+		t.Helper()
+		keys, err := store.List(ctx, bucket, prefix)
+		if err != nil {
+			t.Fatalf("List(%s): %v", prefix, err)
+		}
+		slices.Sort(keys)
+		return keys
+	}
+
+	t.Run("PutGet", func(t *testing.T) {
+		put(t, "putget/a.png", "image bytes", "image/png", map[string]string{"GitHub-Username": "octocat"})
+
+		r, info, err := store.Get(ctx, bucket, "putget/a.png")
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("reading object: %v", err)
+		}
+		if string(data) != "image bytes" {
+			t.Errorf("Get returned %q, want %q", data, "image bytes")
+		}
+		if info.Size != int64(len("image bytes")) || info.ContentType != "image/png" {
+			t.Errorf("Get info = size %d, type %q; want %d, image/png", info.Size, info.ContentType, len("image bytes"))
+		}
+
+		info, err = store.StatInfo(ctx, bucket, "putget/a.png")
+		if err != nil {
+			t.Fatalf("StatInfo: %v", err)
+		}
+		if got := info.Metadata[MetaGitHubUsername]; got != "octocat" {
+			t.Errorf("metadata %s = %q, want octocat (metadata: %v)", MetaGitHubUsername, got, info.Metadata)
+		}
+		if url, err := store.Stat(ctx, bucket, "putget/a.png"); err != nil || url != store.GetURL(bucket, "putget/a.png") {
+			t.Errorf("Stat = %q, %v; want %q", url, err, store.GetURL(bucket, "putget/a.png"))
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		if _, err := store.Stat(ctx, bucket, "missing/a.png"); err == nil {
+			t.Error("Stat of a missing object succeeded")
+		}
+		if _, err := store.StatInfo(ctx, bucket, "missing/a.png"); err == nil {
+			t.Error("StatInfo of a missing object succeeded")
+		}
+		if r, _, err := store.Get(ctx, bucket, "missing/a.png"); err == nil {
+			r.Close()
+			t.Error("Get of a missing object succeeded")
+		}
+	})
+
+	t.Run("FailedPut", func(t *testing.T) {
+		// A reader that fails midway must not leave a truncated object behind
+		r := io.MultiReader(strings.NewReader("partial"), errReader{errors.New("read failed")})
+		if _, err := store.Put(ctx, bucket, "failed/a.png", r, 100, "image/png", nil); err == nil {
+			t.Fatal("Put from a failing reader succeeded")
+		}
+		if _, err := store.StatInfo(ctx, bucket, "failed/a.png"); err == nil {
+			t.Error("failed Put left an object behind")
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		put(t, "list/a/1.png", "1", "image/png", nil)
+		put(t, "list/a/2.png", "2", "image/png", nil)
+		put(t, "list/b/1.png", "3", "image/png", nil)
+
+		if got, want := list(t, "list/a/"), []string{"list/a/1.png", "list/a/2.png"}; !slices.Equal(got, want) {
+			t.Errorf("List(list/a/) = %v, want %v", got, want)
+		}
+		if got := list(t, "list/"); len(got) != 3 {
+			t.Errorf("List(list/) = %v, want 3 keys", got)
+		}
+		if got := list(t, "list/none/"); len(got) != 0 {
+			t.Errorf("List(list/none/) = %v, want none", got)
+		}
+
+		folders, err := store.ListTopLevelFolders(ctx, bucket)
+		if err != nil {
+			t.Fatalf("ListTopLevelFolders: %v", err)
+		}
+		if !slices.Contains(folders, "list") || slices.Contains(folders, "list/a") {
+			t.Errorf("ListTopLevelFolders = %v, want it to contain list and only top-level names", folders)
+		}
+	})
+
+	t.Run("Copy", func(t *testing.T) {
+		put(t, "copy/src.png", "copied", "image/png", map[string]string{MetaModelName: "fake"})
+		if err := store.Copy(ctx, bucket, "copy/src.png", bucket, "copy/dst.png"); err != nil {
+			t.Fatalf("Copy: %v", err)
+		}
+		r, info, err := store.Get(ctx, bucket, "copy/dst.png")
+		if err != nil {
+			t.Fatalf("Get copy: %v", err)
+		}
+		defer r.Close()
+		data, _ := io.ReadAll(r)
+		if !bytes.Equal(data, []byte("copied")) || info.ContentType != "image/png" {
+			t.Errorf("copy = %q (%s), want %q (image/png)", data, info.ContentType, "copied")
+		}
+		if info, err := store.StatInfo(ctx, bucket, "copy/dst.png"); err != nil || info.Metadata[MetaModelName] != "fake" {
+			t.Errorf("copy metadata = %v, %v; want %s=fake", info.Metadata, err, MetaModelName)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		put(t, "delete/poll/1.png", "1", "image/png", nil)
+		put(t, "delete/poll/nested/2.png", "2", "image/png", nil)
+		put(t, "delete/keep/3.png", "3", "image/png", nil)
+
+		if err := store.Delete(ctx, bucket, "delete/poll/"); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if got := list(t, "delete/"); !slices.Equal(got, []string{"delete/keep/3.png"}) {
+			t.Errorf("after Delete, List(delete/) = %v, want only delete/keep/3.png", got)
+		}
+		if err := store.Delete(ctx, bucket, "delete/none/"); err != nil {
+			t.Errorf("Delete of an empty prefix: %v", err)
+		}
+	})
+
+	t.Run("DeleteObject", func(t *testing.T) {
+		put(t, "deleteobject/a.png", "1", "image/png", nil)
+		put(t, "deleteobject/a.png.ref", "2", "application/json", nil)
+
+		if err := store.DeleteObject(ctx, bucket, "deleteobject/a.png"); err != nil {
+			t.Fatalf("DeleteObject: %v", err)
+		}
+		if got := list(t, "deleteobject/"); !slices.Equal(got, []string{"deleteobject/a.png.ref"}) {
+			t.Errorf("after DeleteObject, List(deleteobject/) = %v, want only deleteobject/a.png.ref", got)
+		}
+		if err := store.DeleteObject(ctx, bucket, "deleteobject/missing.png"); err != nil {
+			t.Errorf("DeleteObject of a missing object: %v", err)
+		}
+	})
+}
+
+// errReader fails every read with err.
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+func TestFSStorage(t *testing.T) {
+	store := &FSStorage{Root: t.TempDir(), PublicURL: "http://localhost:8080/media"}
+	testObjectStorage(t, store, "test-bucket")
+}
+
+func TestS3CompatibleStorage(t *testing.T) {
+	srv := newFakeS3(t)
+	host := strings.TrimPrefix(srv.URL, "http://")
+	store := &S3CompatibleStorage{
+		Platform:       S3PlatformMinio,
+		Endpoint:       host,
+		PublicEndpoint: host,
+		Region:         "us-east-1",
+		AccessKey:      "test",
+		SecretKey:      "test-secret",
+	}
+	testObjectStorage(t, store, "test-bucket")
+}
+
+func TestS3Storage(t *testing.T) {
+	srv := newFakeS3(t)
+	store := NewS3Storage(&Config{
+		AWSS3Endpoint: srv.URL,
+		AWSRegion:     "us-east-1",
+		AWSAccessKey:  "test",
+		AWSSecretKey:  "test-secret",
+	})
+	testObjectStorage(t, store, "test-bucket")
+}
+
+func TestGCSStorage(t *testing.T) {
+	srv := newFakeGCS(t)
+	t.Setenv("STORAGE_EMULATOR_HOST", srv.URL)
+	testObjectStorage(t, &GCSStorage{}, "test-bucket")
+}
+
+func TestFSStorageServeHTTP(t *testing.T) {
+	ctx := context.Background()
+	store := &FSStorage{Root: t.TempDir(), PublicURL: "http://localhost:8080/media", SigningKey: "secret"}
+	if _, err := store.Put(ctx, "b", "poll/a.png", strings.NewReader("png"), 3, "image/png", nil); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	get := func(target string) int {
+		rec := httptest.NewRecorder()
+		store.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec.Code
+	}
+
+	signed, err := store.GetPresignedURL(ctx, "b", "poll/a.png", time.Minute)
+	if err != nil {
+		t.Fatalf("GetPresignedURL: %v", err)
+	}
+	if code := get(strings.TrimPrefix(signed, store.PublicURL)); code != http.StatusOK {
+		t.Errorf("presigned request: status %d, want 200", code)
+	}
+	if code := get("/b/poll/a.png"); code != http.StatusForbidden {
+		t.Errorf("unsigned request: status %d, want 403", code)
+	}
+	if code := get("/b/poll/.a.png.meta.json"); code != http.StatusNotFound {
+		t.Errorf("sidecar request: status %d, want 404", code)
+	}
+}
@@ -15,8 +15,16 @@ type AppInput struct {
 	ImageHeight                   int    `json:"image_height,omitempty"`
 	StorageProvider               string `json:"storage_provider"` // "minio", "s3", "gcs", etc.
 	StorageBucket                 string `json:"storage_bucket"`
-	StorageKey                    string `json:"storage_key,omitempty"` // Optional: custom storage key
-	PollID                        string `json:"poll_id,omitempty"`     // Optional: if content is for a poll
+	StorageKey                    string `json:"storage_key,omitempty"`            // Optional: custom storage key
+	PollID                        string `json:"poll_id,omitempty"`                // Optional: if content is for a poll
+	Watermark                     bool   `json:"watermark,omitempty"`              // Stamp a visible watermark and provenance metadata on the images
+	ImageCandidates               int    `json:"image_candidates,omitempty"`       // Images to generate and choose from (default 1)
+	ImageMaxAttempts              int    `json:"image_max_attempts,omitempty"`     // Bound on model calls (default candidates+2)
+	ImageScorer                   string `json:"image_scorer,omitempty"`           // Candidate scorer: "vision" (default) or "heuristic"
+	UseAvatar                     bool   `json:"use_avatar,omitempty"`             // Condition the image on the user's GitHub avatar unless they opted out
+	ContributionAnimation         string `json:"contribution_animation,omitempty"` // Also render the contribution year as "gif" or "webp"
+	// LLMBudget caps the research agent's spend; once it's spent the agent submits what it has
+	LLMBudget LLMBudget `json:"llm_budget,omitempty"`
 }
 
 // AppOutput represents the output of the content generation workflow
@@ -30,7 +38,26 @@ type AppOutput struct {
 	ImageHeight             int           `json:"image_height,omitempty"`
 	StorageURL              string        `json:"storage_url,omitempty"`
 	StorageKey              string        `json:"storage_key,omitempty"`
-	CreatedAt               time.Time     `json:"created_at"`
+	// Variants holds every stored rendition of the image, keyed by name (thumb, card, full, og, original)
+	Variants map[string]ImageVariant `json:"variants,omitempty"`
+	// PerceptualHash is the dHash of the generated image (see perceptualHash)
+	PerceptualHash string `json:"perceptual_hash,omitempty"`
+	// Candidates lists every image generated when choosing among several, best first
+	Candidates []ImageCandidate `json:"candidates,omitempty"`
+	// AvatarKey is the blob of the avatar the image was conditioned on, if any
+	AvatarKey string `json:"avatar_key,omitempty"`
+	// Animation is the contribution calendar filling in week by week, if requested
+	Animation *ContentAsset `json:"animation,omitempty"`
+	// Usage is what the research agent and image model calls cost, by model
+	Usage Usage `json:"usage,omitempty"`
+	// BudgetExhausted says why the research agent was cut short, in which case the profile is partial
+	BudgetExhausted string    `json:"budget_exhausted,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// Srcset returns the srcset attribute value for the image's responsive variants.
+func (o AppOutput) Srcset() string {
+	return srcset(o.Variants)
 }
 
 // WorkflowState represents the current state of the content generation workflow
@@ -38,6 +65,18 @@ type WorkflowState struct {
 	Status    string    `json:"status"`
 	Result    AppOutput `json:"result"`
 	Completed bool      `json:"completed"`
+	// Usage accumulates as steps finish, so it can be watched while the workflow runs
+	Usage Usage `json:"usage,omitempty"`
+	// BudgetExhausted is set once the research agent runs out of LLM budget
+	BudgetExhausted string `json:"budget_exhausted,omitempty"`
+}
+
+// ProfileScrapeResult is the output of AgenticScrapeGitHubProfileWorkflow.
+type ProfileScrapeResult struct {
+	Profile GitHubProfile `json:"profile"`
+	Usage   Usage         `json:"usage,omitempty"`
+	// BudgetExhausted says why the agent was forced to submit early, if it was
+	BudgetExhausted string `json:"budget_exhausted,omitempty"`
 }
 
 // GitHubProfile represents scraped GitHub profile data
@@ -47,15 +86,15 @@ type GitHubProfile struct {
 	Location            string            `json:"location"`
 	Website             string            `json:"website"`
 	PublicRepos         int               `json:"public_repos"`
-	OriginalRepos       int               `json:"original_repos"`
+	OriginalRepos       int               `json:"original_repos" jsonschema:"description=Public repositories that are not forks"`
 	ForkedRepos         int               `json:"forked_repos"`
-	Languages           []string          `json:"languages"`
-	TopRepositories     []Repository      `json:"top_repositories"`
+	Languages           []string          `json:"languages" jsonschema:"description=Languages used across the user's repositories, most used first"`
+	TopRepositories     []Repository      `json:"top_repositories" jsonschema:"description=The user's most notable repositories"`
 	ContributionGraph   ContributionGraph `json:"contribution_graph"`
-	ProfessionalScore   float64           `json:"professional_score"`
-	SafetyFlags         []string          `json:"safety_flags"`
-	CodeSnippets        []CodeSnippet     `json:"code_snippets"`
-	ProfessionalSummary string            `json:"professional_summary"`
+	ProfessionalScore   float64           `json:"professional_score" jsonschema:"description=How professional the profile looks, from 0 to 10"`
+	SafetyFlags         []string          `json:"safety_flags" jsonschema:"description=Concerns about depicting this profile, such as offensive content; empty if none"`
+	CodeSnippets        []CodeSnippet     `json:"code_snippets" jsonschema:"description=Short, characteristic excerpts of the user's code"`
+	ProfessionalSummary string            `json:"professional_summary" jsonschema:"description=A few sentences on who this developer is and what they work on"`
 }
 
 // Repository represents a GitHub repository
@@ -65,7 +104,7 @@ type Repository struct {
 	Language    string    `json:"language"`
 	Stars       int       `json:"stars"`
 	Forks       int       `json:"forks"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	UpdatedAt   time.Time `json:"updated_at" jsonschema:"description=Last push as an RFC 3339 timestamp,nullable"`
 	IsFork      bool      `json:"is_fork"`
 }
 
@@ -73,7 +112,7 @@ type Repository struct {
 type ContributionGraph struct {
 	TotalContributions int            `json:"total_contributions"`
 	Streak             int            `json:"streak"`
-	Contributions      map[string]int `json:"contributions"` // date -> count
+	Contributions      map[string]int `json:"contributions" jsonschema:"description=Contributions per day over the last year, keyed by YYYY-MM-DD"`
 }
 
 // CodeSnippet represents a code snippet from the profile
@@ -88,6 +127,8 @@ type Tool struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	Parameters  any    `json:"parameters"`
+	// Strict asks providers that support it to enforce Parameters exactly
+	Strict bool `json:"strict,omitempty"`
 }
 
 type ToolCall struct {
@@ -96,12 +137,6 @@ type ToolCall struct {
 	Arguments string `json:"arguments"`
 }
 
-type GenerateResponsesTurnResult struct {
-	Assistant string     `json:"assistant"`
-	Calls     []ToolCall `json:"calls"`
-	ID        string     `json:"id"`
-}
-
 // ParsedPollRequest holds the structured data extracted from the user's poll request.
 type ParsedPollRequest struct {
 	Question  string   `json:"question"`
@@ -109,17 +144,22 @@ type ParsedPollRequest struct {
 }
 
 type GenerationResult struct {
-	Prompt      string
-	ImageData   []byte
-	PublicURL   string
-	StorageKey  string
-	ContentType string
+	Prompt         string
+	PublicURL      string
+	StorageKey     string
+	ContentType    string
+	Variants       map[string]ImageVariant
+	PerceptualHash string
+	Candidates     []ImageCandidate
+	RefKey         string // reference record of the image, unless stored at an explicit key
+	Usage          Usage  // tokens and images spent generating and scoring candidates
 }
 
 // PollImageGenerationInput defines the input for the GeneratePollImagesWorkflow.
 type PollImageGenerationInput struct {
 	Usernames []string
 	PollID    string
+	Question  string // drawn on the poll's collage
 	AppInput  AppInput
 }
 
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+)
+
+// usageFolder is the top-level folder holding one usage record per day and run, read by
+// the /admin/costs report.
+const usageFolder = "usage"
+
+// TokenUsage is what model calls consumed. CachedTokens is the part of InputTokens the
+// provider served from its prompt cache, which is billed at a discount.
+type TokenUsage struct {
+	Calls        int     `json:"calls"`
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	CachedTokens int     `json:"cached_tokens,omitempty"`
+	Images       int     `json:"images,omitempty"`
+	CostUSD      float64 `json:"cost_usd"`
+}
+
+// Add adds o to u.
+func (u *TokenUsage) Add(o TokenUsage) {
+	u.Calls += o.Calls
+	u.InputTokens += o.InputTokens
+	u.OutputTokens += o.OutputTokens
+	u.CachedTokens += o.CachedTokens
+	u.Images += o.Images
+	u.CostUSD += o.CostUSD
+}
+
+// Usage is token usage keyed by model name.
+type Usage map[string]TokenUsage
+
+// Add adds t to model's usage.
+func (u *Usage) Add(model string, t TokenUsage) {
+	if *u == nil {
+		*u = make(Usage)
+	}
+	total := (*u)[model]
+	total.Add(t)
+	(*u)[model] = total
+}
+
+// Merge adds every model's usage in o to u.
+func (u *Usage) Merge(o Usage) {
+	for model, t := range o {
+		u.Add(model, t)
+	}
+}
+
+// Total sums the usage of every model.
+func (u Usage) Total() TokenUsage {
+	var total TokenUsage
+	for _, t := range u {
+		total.Add(t)
+	}
+	return total
+}
+
+// LLMBudget caps what one profile's research agent may spend. Zero fields are unlimited.
+type LLMBudget struct {
+	MaxTokens  int     `json:"max_tokens,omitempty"`   // input plus output tokens
+	MaxCostUSD float64 `json:"max_cost_usd,omitempty"` // priced with the configured price table
+}
+
+// Exhausted returns why u has used up b, or "" while there's budget left.
+func (b LLMBudget) Exhausted(u TokenUsage) string {
+	if tokens := u.InputTokens + u.OutputTokens; b.MaxTokens > 0 && tokens >= b.MaxTokens {
+		return fmt.Sprintf("token budget exhausted (%d of %d tokens)", tokens, b.MaxTokens)
+	}
+	if b.MaxCostUSD > 0 && u.CostUSD >= b.MaxCostUSD {
+		return fmt.Sprintf("cost budget exhausted ($%.4f of $%.4f)", u.CostUSD, b.MaxCostUSD)
+	}
+	return ""
+}
+
+// ModelPrice is a model's list price in USD. Token prices are per million tokens; Image is
+// per image, for models billed that way.
+type ModelPrice struct {
+	Input       float64 `json:"input"`
+	CachedInput float64 `json:"cached_input,omitempty"` // defaults to Input
+	Output      float64 `json:"output"`
+	Image       float64 `json:"image,omitempty"`
+}
+
+// defaultModelPrices are list prices at the time of writing. MODEL_PRICES_FILE overrides
+// or extends them; models missing from both are counted but cost nothing.
+var defaultModelPrices = map[string]ModelPrice{
+	"gpt-5":                  {Input: 1.25, CachedInput: 0.125, Output: 10},
+	"gpt-5-mini":             {Input: 0.25, CachedInput: 0.025, Output: 2},
+	"gpt-4.1":                {Input: 2, CachedInput: 0.5, Output: 8},
+	"gpt-4.1-mini":           {Input: 0.4, CachedInput: 0.1, Output: 1.6},
+	"gpt-4.1-nano":           {Input: 0.1, CachedInput: 0.025, Output: 0.4},
+	"gpt-4o":                 {Input: 2.5, CachedInput: 1.25, Output: 10},
+	"gpt-4o-mini":            {Input: 0.15, CachedInput: 0.075, Output: 0.6},
+	"claude-sonnet-4":        {Input: 3, CachedInput: 0.3, Output: 15},
+	"claude-opus-4":          {Input: 15, CachedInput: 1.5, Output: 75},
+	"gemini-2.5-pro":         {Input: 1.25, CachedInput: 0.31, Output: 10},
+	"gemini-2.5-flash":       {Input: 0.3, CachedInput: 0.075, Output: 2.5},
+	"gemini-2.5-flash-image": {Input: 0.3, CachedInput: 0.075, Output: 30},
+	"gpt-image-1":            {Input: 5, CachedInput: 1.25, Output: 40},
+	"dall-e-3":               {Image: 0.04},
+	"dall-e-2":               {Image: 0.02},
+}
+
+// loadModelPrices reads a JSON object mapping model names to ModelPrice from path into
+// prices, replacing the entries it names.
+func loadModelPrices(path string, prices map[string]ModelPrice) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var overrides map[string]ModelPrice
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return fmt.Errorf("invalid price table: %w", err)
+	}
+	for model, price := range overrides {
+		prices[model] = price
+	}
+	return nil
+}
+
+// modelPrice returns the price of model: an exact match, or else the longest name model
+// starts with, so dated snapshots like gpt-4.1-2025-04-14 are priced like their family.
+func modelPrice(prices map[string]ModelPrice, model string) (ModelPrice, bool) {
+	if p, ok := prices[model]; ok {
+		return p, true
+	}
+	best := ""
+	for name := range prices {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return ModelPrice{}, false
+	}
+	return prices[best], true
+}
+
+// priceUsage fills in u.CostUSD from cfg's price table.
+func priceUsage(cfg *Config, model string, u TokenUsage) TokenUsage {
+	p, ok := modelPrice(cfg.ModelPrices, model)
+	if !ok {
+		return u
+	}
+	cachedPrice := p.CachedInput
+	if cachedPrice == 0 {
+		cachedPrice = p.Input
+	}
+	uncached := u.InputTokens - u.CachedTokens
+	u.CostUSD = (float64(uncached)*p.Input+float64(u.CachedTokens)*cachedPrice+float64(u.OutputTokens)*p.Output)/1e6 +
+		float64(u.Images)*p.Image
+	return u
+}
+
+type usageRecorderKey struct{}
+
+// usageRecorder collects the usage of model calls made with a context from
+// withUsageRecorder, so image generators and scorers can report usage without
+// threading it through every return value.
+type usageRecorder struct {
+	mu    sync.Mutex
+	usage Usage
+}
+
+// withUsageRecorder returns a context whose model calls are recorded in the returned recorder.
+func withUsageRecorder(ctx context.Context) (context.Context, *usageRecorder) {
+	r := &usageRecorder{}
+	return context.WithValue(ctx, usageRecorderKey{}, r), r
+}
+
+// recordUsage prices u and adds it to the recorder in ctx, if there is one.
+func recordUsage(ctx context.Context, model string, u TokenUsage) {
+	r, ok := ctx.Value(usageRecorderKey{}).(*usageRecorder)
+	if !ok {
+		return
+	}
+	u = priceUsage(appConfig, model, u)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.usage.Add(model, u)
+}
+
+// Usage returns everything recorded so far.
+func (r *usageRecorder) Usage() Usage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make(Usage, len(r.usage))
+	out.Merge(r.usage)
+	return out
+}
+
+// UsageRecord is the usage of one workflow run (or part of one), stored under
+// usage/<day>/ for the cost report.
+type UsageRecord struct {
+	WorkflowID string    `json:"workflow_id"`
+	Username   string    `json:"username,omitempty"`
+	PollID     string    `json:"poll_id,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	Usage      Usage     `json:"usage"`
+}
+
+// usageDayPrefix returns the folder holding the usage records of day.
+func usageDayPrefix(day time.Time) string {
+	return usageFolder + "/" + day.UTC().Format(time.DateOnly) + "/"
+}
+
+// writeUsageRecord stores rec under name in the folder of its day.
+func writeUsageRecord(ctx context.Context, storage ObjectStorage, bucket, name string, rec UsageRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal usage record: %w", err)
+	}
+	key := usageDayPrefix(rec.CreatedAt) + name + ".json"
+	if _, err := storage.Put(ctx, bucket, key, bytes.NewReader(data), int64(len(data)), "application/json", nil); err != nil {
+		return fmt.Errorf("failed to store usage record: %w", err)
+	}
+	return nil
+}
+
+// RecordUsageInput defines the input for the RecordUsage activity.
+type RecordUsageInput struct {
+	StorageBucket string
+	Username      string
+	PollID        string
+	Usage         Usage
+}
+
+// RecordUsage stores the usage of the calling workflow run for the cost report. A run may
+// record several times; each activity gets its own record.
+func RecordUsage(ctx context.Context, input RecordUsageInput) error {
+	if len(input.Usage) == 0 {
+		return nil
+	}
+	info := activity.GetInfo(ctx)
+	rec := UsageRecord{
+		WorkflowID: info.WorkflowExecution.ID,
+		Username:   input.Username,
+		PollID:     input.PollID,
+		CreatedAt:  time.Now().UTC(),
+		Usage:      input.Usage,
+	}
+	name := fmt.Sprintf("%s-%s-%s", info.WorkflowExecution.ID, info.WorkflowExecution.RunID, info.ActivityID)
+	return writeUsageRecord(ctx, appStorage, input.StorageBucket, name, rec)
+}
+
+// CostRow is one line of the cost report.
+type CostRow struct {
+	Label string
+	Runs  int
+	TokenUsage
+}
+
+// costSection is one table of the cost report page.
+type costSection struct {
+	Heading string
+	Rows    []CostRow
+}
+
+// CostReport is usage over a range of days, grouped three ways.
+type CostReport struct {
+	Days    int
+	Total   TokenUsage
+	ByDay   []CostRow
+	ByPoll  []CostRow
+	ByModel []CostRow
+}
+
+// buildCostReport reads the usage records of the last days days, today included.
+func buildCostReport(ctx context.Context, storage ObjectStorage, bucket string, days int, now time.Time) (CostReport, error) {
+	report := CostReport{Days: days}
+	byPoll := make(map[string]*CostRow)
+	byModel := make(map[string]*CostRow)
+
+	for i := 0; i < days; i++ {
+		day := now.AddDate(0, 0, -i)
+		keys, err := storage.List(ctx, bucket, usageDayPrefix(day))
+		if err != nil {
+			return report, fmt.Errorf("failed to list usage records: %w", err)
+		}
+		if len(keys) == 0 {
+			continue
+		}
+
+		row := CostRow{Label: day.UTC().Format(time.DateOnly)}
+		for _, key := range keys {
+			rec, err := readUsageRecord(ctx, storage, bucket, key)
+			if err != nil {
+				return report, err
+			}
+			total := rec.Usage.Total()
+			row.Runs++
+			row.Add(total)
+			report.Total.Add(total)
+
+			if rec.PollID != "" {
+				if byPoll[rec.PollID] == nil {
+					byPoll[rec.PollID] = &CostRow{Label: rec.PollID}
+				}
+				byPoll[rec.PollID].Runs++
+				byPoll[rec.PollID].Add(total)
+			}
+			for model, t := range rec.Usage {
+				if byModel[model] == nil {
+					byModel[model] = &CostRow{Label: model}
+				}
+				byModel[model].Runs++
+				byModel[model].Add(t)
+			}
+		}
+		report.ByDay = append(report.ByDay, row)
+	}
+
+	report.ByPoll = sortedCostRows(byPoll)
+	report.ByModel = sortedCostRows(byModel)
+	return report, nil
+}
+
+func readUsageRecord(ctx context.Context, storage ObjectStorage, bucket, key string) (UsageRecord, error) {
+	rc, _, err := storage.Get(ctx, bucket, key)
+	if err != nil {
+		return UsageRecord{}, fmt.Errorf("failed to read usage record %s: %w", path.Base(key), err)
+	}
+	defer rc.Close()
+	var rec UsageRecord
+	if err := json.NewDecoder(rc).Decode(&rec); err != nil {
+		return UsageRecord{}, fmt.Errorf("failed to parse usage record %s: %w", path.Base(key), err)
+	}
+	return rec, nil
+}
+
+// sortedCostRows returns rows most expensive first.
+func sortedCostRows(rows map[string]*CostRow) []CostRow {
+	out := make([]CostRow, 0, len(rows))
+	for _, row := range rows {
+		out = append(out, *row)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CostUSD != out[j].CostUSD {
+			return out[i].CostUSD > out[j].CostUSD
+		}
+		return out[i].Label < out[j].Label
+	})
+	return out
+}
+
+// maxCostReportDays bounds how far back /admin/costs reads, since each day is a listing.
+const maxCostReportDays = 366
+
+// handleGetAdminCosts renders spend over the last ?days= days (default 30) by day, poll
+// and model. ?format=json returns the report as JSON.
+func (s *APIServer) handleGetAdminCosts() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		days := 30
+		if v := r.URL.Query().Get("days"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxCostReportDays {
+				s.writeBadRequest(w, r, fmt.Sprintf("days must be between 1 and %d", maxCostReportDays))
+				return
+			}
+			days = n
+		}
+
+		report, err := buildCostReport(r.Context(), s.storageProvider, s.cfg.StorageBucket, days, time.Now())
+		if err != nil {
+			s.logger.Error("failed to build cost report", "error", err)
+			s.writeInternalError(w, r, "Failed to build cost report: "+err.Error())
+			return
+		}
+
+		if r.URL.Query().Get("format") == "json" {
+			s.writeOK(w, report)
+			return
+		}
+		data := map[string]interface{}{
+			"Title":  "Costs",
+			"Report": report,
+			"Sections": []costSection{
+				{Heading: "day", Rows: report.ByDay},
+				{Heading: "poll", Rows: report.ByPoll},
+				{Heading: "model", Rows: report.ByModel},
+			},
+		}
+		if err := s.renderer.RenderWithRequest(w, r, "admin-costs", data); err != nil {
+			s.logger.Error("failed to render template", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	})
+}
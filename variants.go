@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"strings"
+
+	"github.com/chai2010/webp"
+	"github.com/gen2brain/avif"
+	"github.com/nfnt/resize"
+)
+
+// Names of the renditions produced for every generated image.
+const (
+	VariantThumb    = "thumb"    // small preview for lists and grids
+	VariantCard     = "card"     // poll cards
+	VariantFull     = "full"     // IMAGE_WIDTH x IMAGE_HEIGHT in IMAGE_FORMAT, the canonical image
+	VariantOG       = "og"       // 1200x630 Open Graph crop
+	VariantOriginal = "original" // lossless PNG of the model output, kept for later re-encoding
+)
+
+const (
+	thumbWidth = 320
+	cardWidth  = 640
+	ogWidth    = 1200
+	ogHeight   = 630
+)
+
+// srcsetVariants are the renditions offered to browsers via srcset, smallest first.
+var srcsetVariants = []string{VariantThumb, VariantCard, VariantFull}
+
+// alternateFormats are additionally encoded for each srcset variant so the image endpoint
+// can serve whatever the browser handles best.
+var alternateFormats = []string{"avif", "webp"}
+
+// imageContentTypes maps every supported IMAGE_FORMAT to its content type.
+var imageContentTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"jpg":  "image/jpeg",
+	"png":  "image/png",
+	"webp": "image/webp",
+	"avif": "image/avif",
+}
+
+// checkImageFormat returns an error if format can't be encoded. An empty format means PNG.
+func checkImageFormat(format string) error {
+	if format == "" {
+		return nil
+	}
+	if _, ok := imageContentTypes[strings.ToLower(format)]; !ok {
+		return fmt.Errorf("unsupported image format %q", format)
+	}
+	return nil
+}
+
+// ImageVariant describes one stored rendition of a generated image.
+type ImageVariant struct {
+	Key         string `json:"key"`
+	URL         string `json:"url,omitempty"`
+	ContentType string `json:"content_type"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	// Alternates maps content types to blobs holding this rendition in other formats.
+	Alternates map[string]string `json:"alternates,omitempty"`
+}
+
+// renderedVariant is an encoded rendition waiting to be stored.
+type renderedVariant struct {
+	Name        string
+	Data        []byte
+	ContentType string
+	Width       int
+	Height      int
+	Alternates  map[string][]byte // content type -> encoded data
+}
+
+// renderVariants decodes the model output once and produces every named rendition from it.
+// imageWidth and imageHeight size the full variant; zero keeps the source dimension.
+// A non-nil stamp watermarks every rendition but the original and embeds its provenance
+// metadata in all of them.
+func renderVariants(originalData []byte, imageFormat string, imageWidth, imageHeight int, stamp *Stamp) ([]renderedVariant, error) {
+	src, srcFormat, err := image.Decode(bytes.NewReader(originalData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	encode := func(img image.Image, format string) ([]byte, string, error) {
+		data, contentType, err := encodeImage(img, format)
+		if err != nil || stamp == nil {
+			return data, contentType, err
+		}
+		data, err = stamp.Embed(data, contentType)
+		return data, contentType, err
+	}
+
+	var variants []renderedVariant
+	add := func(name string, img image.Image, format string, alternates ...string) error {
+		if stamp != nil {
+			marked, err := stamp.Watermark(img)
+			if err != nil {
+				return fmt.Errorf("failed to watermark %s variant: %w", name, err)
+			}
+			img = marked
+		}
+		data, contentType, err := encode(img, format)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s variant: %w", name, err)
+		}
+		b := img.Bounds()
+		variant := renderedVariant{Name: name, Data: data, ContentType: contentType, Width: b.Dx(), Height: b.Dy()}
+		for _, alt := range alternates {
+			if imageContentTypes[alt] == contentType {
+				continue
+			}
+			altData, altType, err := encode(img, alt)
+			if err != nil {
+				return fmt.Errorf("failed to encode %s variant as %s: %w", name, alt, err)
+			}
+			if variant.Alternates == nil {
+				variant.Alternates = make(map[string][]byte)
+			}
+			variant.Alternates[altType] = altData
+		}
+		variants = append(variants, variant)
+		return nil
+	}
+
+	// The original is kept losslessly and without a watermark; re-encode only if the model
+	// didn't return PNG
+	original := originalData
+	if srcFormat != "png" {
+		if original, _, err = encodeImage(src, "png"); err != nil {
+			return nil, fmt.Errorf("failed to encode original variant: %w", err)
+		}
+	}
+	if stamp != nil {
+		if original, err = stamp.Embed(original, "image/png"); err != nil {
+			return nil, fmt.Errorf("failed to embed provenance in original variant: %w", err)
+		}
+	}
+	b := src.Bounds()
+	variants = append(variants, renderedVariant{Name: VariantOriginal, Data: original, ContentType: "image/png", Width: b.Dx(), Height: b.Dy()})
+
+	full := src
+	if imageWidth > 0 || imageHeight > 0 {
+		full = resize.Resize(uint(imageWidth), uint(imageHeight), src, resize.Lanczos3)
+	}
+	if err := add(VariantFull, full, imageFormat, alternateFormats...); err != nil {
+		return nil, err
+	}
+	if err := add(VariantCard, fitWidth(full, cardWidth), imageFormat, alternateFormats...); err != nil {
+		return nil, err
+	}
+	if err := add(VariantThumb, fitWidth(full, thumbWidth), imageFormat, alternateFormats...); err != nil {
+		return nil, err
+	}
+	// Link unfurlers handle JPEG most reliably
+	if err := add(VariantOG, coverCrop(src, ogWidth, ogHeight), "jpeg"); err != nil {
+		return nil, err
+	}
+
+	return variants, nil
+}
+
+// encodeImage encodes img in the given format and returns the bytes and content type.
+// An empty format means PNG; unknown formats are an error rather than a silent fallback.
+func encodeImage(img image.Image, imageFormat string) ([]byte, string, error) {
+	if err := checkImageFormat(imageFormat); err != nil {
+		return nil, "", err
+	}
+
+	var buf bytes.Buffer
+	var contentType string
+	var err error
+
+	switch strings.ToLower(imageFormat) {
+	case "jpeg", "jpg":
+		contentType = "image/jpeg"
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80})
+	case "webp":
+		contentType = "image/webp"
+		err = webp.Encode(&buf, img, &webp.Options{Quality: 75})
+	case "avif":
+		contentType = "image/avif"
+		err = avif.Encode(&buf, img, avif.Options{Quality: 60, Speed: 8})
+	case "png", "":
+		contentType = "image/png"
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), contentType, nil
+}
+
+// fitWidth scales img down to width, preserving its aspect ratio. Smaller images are left alone.
+func fitWidth(img image.Image, width int) image.Image {
+	if img.Bounds().Dx() <= width {
+		return img
+	}
+	return resize.Resize(uint(width), 0, img, resize.Lanczos3)
+}
+
+// coverCrop scales img to cover width x height and crops the overflow evenly from both sides.
+func coverCrop(img image.Image, width, height int) image.Image {
+	b := img.Bounds()
+	scale := math.Max(float64(width)/float64(b.Dx()), float64(height)/float64(b.Dy()))
+	scaled := resize.Resize(uint(math.Ceil(float64(b.Dx())*scale)), uint(math.Ceil(float64(b.Dy())*scale)), img, resize.Lanczos3)
+
+	sb := scaled.Bounds()
+	offset := image.Pt(sb.Min.X+(sb.Dx()-width)/2, sb.Min.Y+(sb.Dy()-height)/2)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), scaled, offset, draw.Src)
+	return dst
+}
+
+// srcset builds an HTML srcset attribute value from the stored variants that have URLs.
+func srcset(variants map[string]ImageVariant) string {
+	var candidates []string
+	for _, name := range srcsetVariants {
+		v, ok := variants[name]
+		if !ok || v.URL == "" || v.Width == 0 {
+			continue
+		}
+		candidates = append(candidates, fmt.Sprintf("%s %dw", v.URL, v.Width))
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// negotiateImageType picks the content type to serve from offers given a request's Accept
+// header. The highest q-value wins, then the most specific match (image/avif beats image/*),
+// then the earlier offer, so a client that only sends */* gets the first offer.
+func negotiateImageType(accept string, offers []string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	best, bestQ, bestSpecificity := offers[0], 0.0, -1
+	for _, offer := range offers {
+		q, specificity := acceptQuality(accept, offer)
+		if q > bestQ || (q == bestQ && q > 0 && specificity > bestSpecificity) {
+			best, bestQ, bestSpecificity = offer, q, specificity
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the q-value Accept gives contentType and how specific the matching
+// range was: 2 for an exact match, 1 for type/*, 0 for */* and -1 for no match.
+func acceptQuality(accept, contentType string) (float64, int) {
+	mainType := strings.SplitN(contentType, "/", 2)[0]
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+
+		s := -1
+		switch mediaType {
+		case contentType:
+			s = 2
+		case mainType + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		}
+		if s <= specificity {
+			continue
+		}
+
+		partQ := 1.0
+		for _, param := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if _, err := fmt.Sscanf(v, "%g", &partQ); err != nil {
+					partQ = 0
+				}
+			}
+		}
+		q, specificity = partQ, s
+	}
+	return q, specificity
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"net/url"
+	"strings"
+
+	"golang.org/x/image/font"
+)
+
+const (
+	// digitalSourceSynthetic is the IPTC digital source type for images made by a generative model.
+	digitalSourceSynthetic = "http://cv.iptc.org/newscodes/digitalsourcetype/trainedAlgorithmicMedia"
+	// xmpNamespace identifies the XMP payload in JPEG APP1 segments.
+	xmpNamespace = "http://ns.adobe.com/xap/1.0/\x00"
+	// watermarkMinSize and watermarkMaxSize bound the watermark font size in pixels.
+	watermarkMinSize = 10
+	watermarkMaxSize = 22
+)
+
+var watermarkBackground = color.RGBA{0x00, 0x00, 0x00, 0x99}
+
+// Stamp marks a generated image as ours and as AI-generated: a visible watermark drawn into
+// the pixels and provenance metadata embedded in the encoded file.
+type Stamp struct {
+	Text       string // visible watermark, e.g. "Vibe Check · example.com/poll/<id>"
+	ModelName  string
+	WorkflowID string
+}
+
+// newStamp builds the stamp for an image generated for pollID, or for username's profile
+// when pollID is empty. The link is left out when PUBLIC_BASE_URL isn't configured.
+func newStamp(pollID, username, modelName, workflowID string) *Stamp {
+	text := siteName
+	if base := strings.TrimSuffix(appConfig.PublicBaseURL, "/"); base != "" {
+		link := base + "/profile/" + url.PathEscape(username)
+		if pollID != "" {
+			link = base + "/poll/" + url.PathEscape(pollID)
+		}
+		text += " · " + strings.TrimPrefix(strings.TrimPrefix(link, "https://"), "http://")
+	}
+	return &Stamp{Text: text, ModelName: modelName, WorkflowID: workflowID}
+}
+
+// Watermark draws the stamp's text on a translucent band in the bottom-right corner of img.
+// The font scales with the image so the mark stays small but legible on every variant.
+func (s *Stamp) Watermark(img image.Image) (image.Image, error) {
+	b := img.Bounds()
+	size := float64(min(max(b.Dx()/40, watermarkMinSize), watermarkMaxSize))
+	face, err := collageFace(size)
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	padding := int(size / 2)
+	text := truncateToWidth(face, s.Text, b.Dx()*3/4)
+	width := font.MeasureString(face, text).Ceil() + 2*padding
+	height := int(size) + 2*padding
+
+	dst := image.NewRGBA(b)
+	draw.Draw(dst, b, img, b.Min, draw.Src)
+	band := image.Rect(b.Max.X-width, b.Max.Y-height, b.Max.X, b.Max.Y)
+	draw.Draw(dst, band, image.NewUniform(watermarkBackground), image.Point{}, draw.Over)
+	drawCentered(dst, face, color.White, text, band.Min.X+width/2, band.Min.Y+height/2)
+	return dst, nil
+}
+
+// Embed adds provenance metadata to an encoded image: an XMP packet marking it as synthetic,
+// plus PNG text chunks for tools that don't read XMP. AVIF files are returned unchanged.
+func (s *Stamp) Embed(data []byte, contentType string) ([]byte, error) {
+	packet := s.xmpPacket()
+	switch contentType {
+	case "image/png":
+		return embedPNGText(data, map[string]string{
+			"Source":            s.ModelName,
+			"Comment":           fmt.Sprintf("Synthetic image generated by %s (workflow %s)", s.ModelName, s.WorkflowID),
+			"DigitalSourceType": digitalSourceSynthetic,
+		}, packet)
+	case "image/jpeg":
+		return embedJPEGXMP(data, packet)
+	case "image/webp":
+		return embedWebPXMP(data, packet)
+	default:
+		// AVIF metadata lives in ISOBMFF boxes that the encoder doesn't expose
+		return data, nil
+	}
+}
+
+// xmpPacket returns the XMP document recording the stamp's provenance.
+func (s *Stamp) xmpPacket() []byte {
+	var buf bytes.Buffer
+	esc := func(v string) string {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(v))
+		return b.String()
+	}
+	fmt.Fprintf(&buf, `<?xpacket begin="%s" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:Iptc4xmpExt="http://iptc.org/std/Iptc4xmpExt/2008-02-29/"
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:g2i="https://github.com/brojonat/g2i/ns/1.0/">
+   <Iptc4xmpExt:DigitalSourceType>%s</Iptc4xmpExt:DigitalSourceType>
+   <xmp:CreatorTool>%s</xmp:CreatorTool>
+   <g2i:ModelName>%s</g2i:ModelName>
+   <g2i:WorkflowID>%s</g2i:WorkflowID>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="r"?>`, "\ufeff", digitalSourceSynthetic, esc(siteName), esc(s.ModelName), esc(s.WorkflowID))
+	return buf.Bytes()
+}
+
+// embedPNGText inserts tEXt chunks for text and an iTXt chunk holding the XMP packet
+// right after the IHDR chunk.
+func embedPNGText(data []byte, text map[string]string, xmp []byte) ([]byte, error) {
+	const sigLen, ihdrLen = 8, 8 + 13 + 4 // chunk length and type, IHDR data, CRC
+	if len(data) < sigLen+ihdrLen || string(data[sigLen+4:sigLen+8]) != "IHDR" {
+		return nil, fmt.Errorf("not a PNG image")
+	}
+
+	var chunks bytes.Buffer
+	for _, keyword := range []string{"Source", "Comment", "DigitalSourceType"} {
+		if v, ok := text[keyword]; ok && v != "" {
+			writePNGChunk(&chunks, "tEXt", append([]byte(keyword+"\x00"), v...))
+		}
+	}
+	// iTXt: keyword, null, compression flag, compression method, language tag, null, translated keyword, null, text
+	writePNGChunk(&chunks, "iTXt", append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), xmp...))
+
+	out := make([]byte, 0, len(data)+chunks.Len())
+	out = append(out, data[:sigLen+ihdrLen]...)
+	out = append(out, chunks.Bytes()...)
+	return append(out, data[sigLen+ihdrLen:]...), nil
+}
+
+// writePNGChunk appends a PNG chunk with its length and CRC.
+func writePNGChunk(buf *bytes.Buffer, chunkType string, payload []byte) {
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(chunkType))
+	crc.Write(payload)
+	buf.Write(binary.BigEndian.AppendUint32(nil, uint32(len(payload))))
+	buf.WriteString(chunkType)
+	buf.Write(payload)
+	buf.Write(binary.BigEndian.AppendUint32(nil, crc.Sum32()))
+}
+
+// embedJPEGXMP inserts an APP1 segment holding the XMP packet right after the SOI marker.
+func embedJPEGXMP(data []byte, xmp []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, fmt.Errorf("not a JPEG image")
+	}
+	payload := append([]byte(xmpNamespace), xmp...)
+	if len(payload)+2 > 0xffff {
+		return nil, fmt.Errorf("XMP packet too large for a JPEG segment")
+	}
+
+	out := make([]byte, 0, len(data)+len(payload)+4)
+	out = append(out, 0xff, 0xd8, 0xff, 0xe1)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(payload)+2))
+	out = append(out, payload...)
+	return append(out, data[2:]...), nil
+}
+
+// embedWebPXMP adds an XMP chunk to a WebP file. Simple (VP8/VP8L) files are converted to
+// the extended format first, since only VP8X files may carry metadata.
+func embedWebPXMP(data []byte, xmp []byte) ([]byte, error) {
+	if len(data) < 20 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("not a WebP image")
+	}
+	body := data[12:]
+
+	const xmpFlag, alphaFlag = 0x04, 0x10
+	switch string(body[0:4]) {
+	case "VP8X":
+		body = append([]byte(nil), body...)
+		body[8] |= xmpFlag
+	case "VP8 ", "VP8L":
+		img, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read WebP dimensions: %w", err)
+		}
+		header := make([]byte, 0, 18+len(body))
+		header = append(header, "VP8X"...)
+		header = binary.LittleEndian.AppendUint32(header, 10)
+		flags := byte(xmpFlag)
+		if string(body[0:4]) == "VP8L" {
+			flags |= alphaFlag
+		}
+		header = append(header, flags, 0, 0, 0)
+		header = appendUint24(header, uint32(img.Width-1))
+		header = appendUint24(header, uint32(img.Height-1))
+		body = append(header, body...)
+	default:
+		return nil, fmt.Errorf("unknown WebP chunk %q", body[0:4])
+	}
+
+	body = append(body, "XMP "...)
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(xmp)))
+	body = append(body, xmp...)
+	if len(xmp)%2 == 1 {
+		body = append(body, 0) // chunks are padded to an even size
+	}
+
+	out := make([]byte, 0, 12+len(body))
+	out = append(out, "RIFF"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(4+len(body)))
+	out = append(out, "WEBP"...)
+	return append(out, body...), nil
+}
+
+// appendUint24 appends v as a 24-bit little-endian integer.
+func appendUint24(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16))
+}
@@ -29,7 +29,7 @@ func RunContentGenerationWorkflow(ctx workflow.Context, input AppInput) (AppOutp
 
 	// Step 1: Scrape GitHub profile
 	state.Status = "Analyzing GitHub profile..."
-	var githubProfile GitHubProfile
+	var scrape ProfileScrapeResult
 	agentSystemPrompt := input.ResearchAgentSystemPrompt
 	agentSystemPrompt += fmt.Sprintf("\n\nScrape this info from the GitHub profile for the user: %s", input.GitHubUsername)
 
@@ -38,42 +38,73 @@ func RunContentGenerationWorkflow(ctx workflow.Context, input AppInput) (AppOutp
 		WorkflowID: "agentic-scrape-" + input.GitHubUsername,
 	}
 	childCtx := workflow.WithChildOptions(ctx, cwo)
-	err = workflow.ExecuteChildWorkflow(childCtx, AgenticScrapeGitHubProfileWorkflow, agentSystemPrompt).Get(childCtx, &githubProfile)
+	err = workflow.ExecuteChildWorkflow(childCtx, AgenticScrapeGitHubProfileWorkflow, agentSystemPrompt, input.LLMBudget).Get(childCtx, &scrape)
 	if err != nil {
 		logger.Error("Failed to scrape GitHub profile", "error", err)
 		return AppOutput{}, err
 	}
+	githubProfile := scrape.Profile
+	state.Usage.Merge(scrape.Usage)
+	if scrape.BudgetExhausted != "" {
+		// Carry on with the partial profile; the status shows why it's thin
+		logger.Warn("Research agent ran out of budget", "reason", scrape.BudgetExhausted)
+		state.BudgetExhausted = scrape.BudgetExhausted
+	}
+
+	// Optionally fetch the developer's avatar so the image looks like them. Any failure
+	// just means text-only generation.
+	var avatarKey string
+	if input.UseAvatar {
+		state.Status = "Fetching avatar..."
+		avatarInput := FetchGitHubAvatarInput{
+			StorageBucket: input.StorageBucket,
+			Username:      input.GitHubUsername,
+			ModelName:     input.ModelName,
+		}
+		if err := workflow.ExecuteActivity(ctx, FetchGitHubAvatar, avatarInput).Get(ctx, &avatarKey); err != nil {
+			logger.Warn("Failed to fetch avatar, generating from text only", "error", err)
+			avatarKey = ""
+		}
+	}
 
 	// Step 2: Generate content generation prompt
 	state.Status = "Generating prompt..."
 	var contentGenerationPrompt string
-	err = workflow.ExecuteActivity(ctx, GenerateContentGenerationPrompt, githubProfile, input.ContentGenerationSystemPrompt).Get(ctx, &contentGenerationPrompt)
+	err = workflow.ExecuteActivity(ctx, GenerateContentGenerationPrompt, githubProfile, input.ContentGenerationSystemPrompt, avatarKey != "").Get(ctx, &contentGenerationPrompt)
 	if err != nil {
 		logger.Error("Failed to generate content generation prompt", "error", err)
 		return AppOutput{}, err
 	}
 
-	// Step 3: Generate content using frontier model
+	// Step 3: Generate content using frontier model. The activity uploads the image
+	// itself and hands back only the storage key, keeping image bytes out of history.
 	state.Status = "Generating image..."
 	var generationResult GenerationResult
-	err = workflow.ExecuteActivity(ctx, GenerateContent, contentGenerationPrompt, input.ModelName, input.ImageFormat, input.ImageWidth, input.ImageHeight).Get(ctx, &generationResult)
-	if err != nil {
-		logger.Error("Failed to generate content", "error", err)
-		return AppOutput{}, err
-	}
-	logger.Info("Content generation completed successfully.")
+	generateInput := GenerateContentInput{
+		Prompt:         contentGenerationPrompt,
+		ModelName:      input.ModelName,
+		ImageFormat:    input.ImageFormat,
+		ImageWidth:     input.ImageWidth,
+		ImageHeight:    input.ImageHeight,
+		StorageBucket:  input.StorageBucket,
+		StorageKey:     input.StorageKey,
+		KeyPrefix:      input.GitHubUsername,
+		GitHubUsername: input.GitHubUsername,
+		Watermark:      input.Watermark,
+		PollID:         input.PollID,
+		Candidates:     input.ImageCandidates,
+		MaxAttempts:    input.ImageMaxAttempts,
+		Scorer:         input.ImageScorer,
 
-	// Store the generated content
-	logger.Info("Storing content...")
-	var storeOutput StoreContentOutput
-	storagePrefix := input.GitHubUsername
-	err = workflow.ExecuteActivity(ctx, StoreContent, generationResult.ImageData, input.StorageProvider, input.StorageBucket, input.StorageKey, storagePrefix, generationResult.ContentType).Get(ctx, &storeOutput)
+		ReferenceImageKey: avatarKey,
+	}
+	err = workflow.ExecuteActivity(ctx, GenerateContent, generateInput).Get(ctx, &generationResult)
 	if err != nil {
-		logger.Error("Failed to store content", "error", err)
+		logger.Error("Failed to generate content", "error", err)
 		return AppOutput{}, err
 	}
-	generationResult.PublicURL = storeOutput.PublicURL
-	generationResult.StorageKey = storeOutput.StorageKey
+	logger.Info("Content generation completed successfully.", "storage_key", generationResult.StorageKey)
+	state.Usage.Merge(generationResult.Usage)
 
 	output := AppOutput{
 		GitHubProfile:           githubProfile,
@@ -85,9 +116,45 @@ func RunContentGenerationWorkflow(ctx workflow.Context, input AppInput) (AppOutp
 		ImageHeight:             input.ImageHeight,
 		StorageURL:              generationResult.PublicURL,
 		StorageKey:              generationResult.StorageKey,
+		Variants:                generationResult.Variants,
+		PerceptualHash:          generationResult.PerceptualHash,
+		Candidates:              generationResult.Candidates,
+		AvatarKey:               avatarKey,
+		Usage:                   state.Usage,
+		BudgetExhausted:         state.BudgetExhausted,
 		CreatedAt:               time.Now(),
 	}
 
+	// Step 4: Optionally animate the contribution calendar. It's a bonus, so failures
+	// don't fail the workflow.
+	if input.ContributionAnimation != "" && len(githubProfile.ContributionGraph.Contributions) > 0 {
+		state.Status = "Animating contributions..."
+		animationInput := RenderContributionAnimationInput{
+			StorageBucket: input.StorageBucket,
+			Username:      input.GitHubUsername,
+			Graph:         githubProfile.ContributionGraph,
+			Format:        input.ContributionAnimation,
+			ImageRefKey:   generationResult.RefKey,
+		}
+		var animation ContentAsset
+		if err := workflow.ExecuteActivity(ctx, RenderContributionAnimation, animationInput).Get(ctx, &animation); err != nil {
+			logger.Warn("Failed to render contribution animation", "error", err)
+		} else {
+			output.Animation = &animation
+		}
+	}
+
+	// Usage only feeds the cost report, so failing to record it doesn't fail the workflow
+	recordInput := RecordUsageInput{
+		StorageBucket: input.StorageBucket,
+		Username:      input.GitHubUsername,
+		PollID:        input.PollID,
+		Usage:         state.Usage,
+	}
+	if err := workflow.ExecuteActivity(ctx, RecordUsage, recordInput).Get(ctx, nil); err != nil {
+		logger.Warn("Failed to record usage", "error", err)
+	}
+
 	state.Status = "Completed"
 	state.Completed = true
 	state.Result = output
@@ -101,7 +168,7 @@ func GeneratePollImagesWorkflow(ctx workflow.Context, input PollImageGenerationI
 	logger := workflow.GetLogger(ctx)
 	logger.Info("Starting poll image generation workflow", "PollID", input.PollID, "UserCount", len(input.Usernames))
 
-	// Set activity options for CopyObject activity
+	// Set activity options for LinkPollImage activity
 	ao := workflow.ActivityOptions{
 		StartToCloseTimeout: 5 * time.Minute,
 	}
@@ -115,6 +182,7 @@ func GeneratePollImagesWorkflow(ctx workflow.Context, input PollImageGenerationI
 		// Start the content generation workflow for each user.
 		childInput := input.AppInput
 		childInput.GitHubUsername = username
+		childInput.PollID = input.PollID
 
 		// Use deterministic workflow IDs to prevent duplicate work on retries
 		childWorkflowID := fmt.Sprintf("content-generation-%s", username)
@@ -128,6 +196,7 @@ func GeneratePollImagesWorkflow(ctx workflow.Context, input PollImageGenerationI
 
 	var errors []string
 	successCount := 0
+	var options []AppOutput
 
 	for _, future := range futures {
 		var childOutput AppOutput
@@ -137,37 +206,62 @@ func GeneratePollImagesWorkflow(ctx workflow.Context, input PollImageGenerationI
 			continue
 		}
 
-		// The image is now generated and stored under the user's "folder".
-		// Now, copy it to the poll's "folder".
+		// The image is now stored as a blob referenced from the user's "folder".
+		// Now, reference it from the poll's "folder" too.
 		if childOutput.StorageKey == "" {
 			errors = append(errors, fmt.Sprintf("Child workflow for %s did not return a storage key", childOutput.GitHubProfile.Username))
 			logger.Warn("Child workflow did not return a storage key")
 			continue
 		}
-		childInput := input.AppInput
 		if !strings.Contains(childOutput.ContentType, "/") {
 			errors = append(errors, fmt.Sprintf("Invalid content type for %s: %s", childOutput.GitHubProfile.Username, childOutput.ContentType))
 			logger.Warn("Child workflow returned invalid content type", "ContentType", childOutput.ContentType)
 			continue
 		}
-		fileExtension := strings.Split(childOutput.ContentType, "/")[1]
-		destKey := fmt.Sprintf("%s/%s.%s", input.PollID, childOutput.GitHubProfile.Username, fileExtension)
+		options = append(options, childOutput)
+	}
+
+	// Options are generated in parallel, so near-duplicates are only checked once all
+	// of them exist. Each option is compared with every other one's current image.
+	for i := range options {
+		options[i] = regenerateNearDuplicate(ctx, input, options[i], otherOptionHashes(options, i))
+	}
 
-		copyActivityInput := CopyObjectInput{
-			SourceBucket:      childInput.StorageBucket,
-			SourceKey:         childOutput.StorageKey,
-			DestinationBucket: childInput.StorageBucket,
-			DestinationKey:    destKey,
-			StorageProvider:   childInput.StorageProvider,
+	for _, option := range options {
+		linkInput := LinkPollImageInput{
+			Bucket:         input.AppInput.StorageBucket,
+			PollID:         input.PollID,
+			Username:       option.GitHubProfile.Username,
+			BlobKey:        option.StorageKey,
+			ContentType:    option.ContentType,
+			Variants:       option.Variants,
+			PerceptualHash: option.PerceptualHash,
 		}
 
-		err := workflow.ExecuteActivity(ctx, CopyObject, copyActivityInput).Get(ctx, nil)
+		err := workflow.ExecuteActivity(ctx, LinkPollImage, linkInput).Get(ctx, nil)
 		if err != nil {
-			errors = append(errors, fmt.Sprintf("Failed to copy %s: %v", destKey, err))
-			logger.Error("Failed to copy image to poll folder", "DestinationKey", destKey, "error", err)
+			errors = append(errors, fmt.Sprintf("Failed to link %s: %v", option.GitHubProfile.Username, err))
+			logger.Error("Failed to link image to poll folder", "Username", option.GitHubProfile.Username, "error", err)
 		} else {
 			successCount++
-			logger.Info("Successfully copied image to poll folder", "DestinationKey", destKey)
+			logger.Info("Successfully linked image to poll folder", "Username", option.GitHubProfile.Username, "Blob", option.StorageKey)
+		}
+	}
+
+	// The collage shows every option side by side, so only compose it once all of them exist
+	if successCount > 0 && successCount == len(input.Usernames) {
+		collageInput := ComposePollCollageInput{
+			Bucket:    input.AppInput.StorageBucket,
+			PollID:    input.PollID,
+			Question:  input.Question,
+			Usernames: input.Usernames,
+		}
+		var key string
+		if err := workflow.ExecuteActivity(ctx, ComposePollCollage, collageInput).Get(ctx, &key); err != nil {
+			errors = append(errors, fmt.Sprintf("Failed to compose collage: %v", err))
+			logger.Error("Failed to compose poll collage", "error", err)
+		} else {
+			logger.Info("Composed poll collage", "key", key)
 		}
 	}
 
@@ -179,3 +273,86 @@ func GeneratePollImagesWorkflow(ctx workflow.Context, input PollImageGenerationI
 
 	return nil
 }
+
+// otherOptionHashes returns the image hashes of every option but options[skip].
+func otherOptionHashes(options []AppOutput, skip int) []pollImageHash {
+	var hashes []pollImageHash
+	for i, option := range options {
+		if i != skip {
+			hashes = append(hashes, pollImageHash{Username: option.GitHubProfile.Username, Hash: option.PerceptualHash})
+		}
+	}
+	return hashes
+}
+
+// regenerateNearDuplicate regenerates an option's image with a varied prompt while it is
+// within nearDuplicateThreshold of another option's image, at most
+// maxDuplicateRegenerations times. The latest image is kept if it is still too similar
+// or regeneration fails, so a poll never loses an option over a lookalike.
+func regenerateNearDuplicate(ctx workflow.Context, input PollImageGenerationInput, output AppOutput, others []pollImageHash) AppOutput {
+	logger := workflow.GetLogger(ctx)
+	username := output.GitHubProfile.Username
+
+	for attempt := 0; attempt < maxDuplicateRegenerations; attempt++ {
+		match, distance := nearestDuplicate(output.PerceptualHash, others)
+		if match == "" {
+			return output
+		}
+		logger.Info("Regenerating near-duplicate poll image", "Username", username, "LooksLike", match, "Distance", distance, "Attempt", attempt+1)
+
+		var prompt string
+		err := workflow.ExecuteActivity(ctx, GenerateContentGenerationPrompt, output.GitHubProfile, input.AppInput.ContentGenerationSystemPrompt, output.AvatarKey != "").Get(ctx, &prompt)
+		if err != nil {
+			logger.Warn("Failed to build prompt for regeneration", "Username", username, "error", err)
+			return output
+		}
+
+		generateInput := GenerateContentInput{
+			Prompt:         prompt,
+			ModelName:      input.AppInput.ModelName,
+			ImageFormat:    input.AppInput.ImageFormat,
+			ImageWidth:     input.AppInput.ImageWidth,
+			ImageHeight:    input.AppInput.ImageHeight,
+			StorageBucket:  input.AppInput.StorageBucket,
+			KeyPrefix:      username,
+			GitHubUsername: username,
+			Watermark:      input.AppInput.Watermark,
+			PollID:         input.PollID,
+			Candidates:     input.AppInput.ImageCandidates,
+			MaxAttempts:    input.AppInput.ImageMaxAttempts,
+			Scorer:         input.AppInput.ImageScorer,
+
+			ReferenceImageKey: output.AvatarKey,
+			Variation:         attempt + 1,
+		}
+		var result GenerationResult
+		if err := workflow.ExecuteActivity(ctx, GenerateContent, generateInput).Get(ctx, &result); err != nil {
+			logger.Warn("Failed to regenerate near-duplicate image", "Username", username, "error", err)
+			return output
+		}
+
+		output.ContentURL = result.PublicURL
+		output.StorageURL = result.PublicURL
+		output.StorageKey = result.StorageKey
+		output.ContentType = result.ContentType
+		output.Variants = result.Variants
+		output.PerceptualHash = result.PerceptualHash
+		output.Candidates = result.Candidates
+		output.Usage.Merge(result.Usage)
+
+		recordInput := RecordUsageInput{
+			StorageBucket: input.AppInput.StorageBucket,
+			Username:      username,
+			PollID:        input.PollID,
+			Usage:         result.Usage,
+		}
+		if err := workflow.ExecuteActivity(ctx, RecordUsage, recordInput).Get(ctx, nil); err != nil {
+			logger.Warn("Failed to record usage", "Username", username, "error", err)
+		}
+	}
+
+	if match, distance := nearestDuplicate(output.PerceptualHash, others); match != "" {
+		logger.Warn("Poll image is still a near-duplicate after regenerating", "Username", username, "LooksLike", match, "Distance", distance)
+	}
+	return output
+}
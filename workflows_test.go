@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/testsuite"
+)
+
+const (
+	testBucket      = "test-bucket"
+	testPromptIntro = "Scrape this info from the GitHub profile for the user: "
+)
+
+// session runs one workflow and returns what should come out the same whether its LLM,
+// image model and gh calls were live or replayed.
+type session func(t *testing.T, env *testsuite.TestWorkflowEnvironment) any
+
+// recordedSession is saved as session.json next to a session's fixtures: the settings it
+// was recorded with, which replay needs to send the same requests, and what it returned.
+type recordedSession struct {
+	Config sessionConfig   `json:"config"`
+	Result json.RawMessage `json:"result"`
+}
+
+// sessionConfig is the part of Config that shapes the requests a session sends. Credentials
+// are left out so session files can be committed.
+type sessionConfig struct {
+	GeminiModel                  string                `json:"gemini_model"`
+	OpenAIBaseURL                string                `json:"openai_base_url,omitempty"`
+	SDBaseURL                    string                `json:"sd_base_url,omitempty"`
+	ComfyUIBaseURL               string                `json:"comfyui_base_url,omitempty"`
+	ResearchOrchestratorProvider string                `json:"research_orchestrator_provider"`
+	ResearchOrchestratorModel    string                `json:"research_orchestrator_model"`
+	ResearchOrchestratorBaseURL  string                `json:"research_orchestrator_base_url,omitempty"`
+	ResearchAgentPrompt          string                `json:"research_agent_prompt"`
+	ContentGenerationPrompt      string                `json:"content_generation_prompt"`
+	ImageFormat                  string                `json:"image_format"`
+	ImageWidth                   int                   `json:"image_width"`
+	ImageHeight                  int                   `json:"image_height"`
+	WatermarkImages              bool                  `json:"watermark_images,omitempty"`
+	ImageCandidates              int                   `json:"image_candidates,omitempty"`
+	ImageMaxAttempts             int                   `json:"image_max_attempts,omitempty"`
+	ImageScorer                  string                `json:"image_scorer,omitempty"`
+	ImageScorerModel             string                `json:"image_scorer_model,omitempty"`
+	ImageUseAvatar               bool                  `json:"image_use_avatar,omitempty"`
+	AvatarOptOut                 []string              `json:"avatar_opt_out,omitempty"`
+	ModelPrices                  map[string]ModelPrice `json:"model_prices,omitempty"`
+	LLMBudget                    LLMBudget             `json:"llm_budget,omitempty"`
+}
+
+func newSessionConfig(cfg *Config) sessionConfig {
+	return sessionConfig{
+		GeminiModel:                  cfg.GeminiModel,
+		OpenAIBaseURL:                cfg.OpenAIBaseURL,
+		SDBaseURL:                    cfg.SDBaseURL,
+		ComfyUIBaseURL:               cfg.ComfyUIBaseURL,
+		ResearchOrchestratorProvider: cfg.ResearchOrchestratorProvider,
+		ResearchOrchestratorModel:    cfg.ResearchOrchestratorModel,
+		ResearchOrchestratorBaseURL:  cfg.ResearchOrchestratorBaseURL,
+		ResearchAgentPrompt:          cfg.ResearchAgentPrompt,
+		ContentGenerationPrompt:      cfg.ContentGenerationPrompt,
+		ImageFormat:                  cfg.ImageFormat,
+		ImageWidth:                   cfg.ImageWidth,
+		ImageHeight:                  cfg.ImageHeight,
+		WatermarkImages:              cfg.WatermarkImages,
+		ImageCandidates:              cfg.ImageCandidates,
+		ImageMaxAttempts:             cfg.ImageMaxAttempts,
+		ImageScorer:                  cfg.ImageScorer,
+		ImageScorerModel:             cfg.ImageScorerModel,
+		ImageUseAvatar:               cfg.ImageUseAvatar,
+		AvatarOptOut:                 cfg.AvatarOptOut,
+		ModelPrices:                  cfg.ModelPrices,
+		LLMBudget:                    cfg.LLMBudget,
+	}
+}
+
+// config returns a Config with c's settings, the credentials of creds and fixtures in
+// mode under dir. ContributionAnimation stays off since its requests depend on the date.
+func (c sessionConfig) config(creds *Config, mode, dir string) *Config {
+	return &Config{
+		StorageBucket:                testBucket,
+		GoogleAPIKey:                 creds.GoogleAPIKey,
+		GeminiModel:                  c.GeminiModel,
+		OpenAIAPIKey:                 creds.OpenAIAPIKey,
+		OpenAIBaseURL:                c.OpenAIBaseURL,
+		SDBaseURL:                    c.SDBaseURL,
+		ComfyUIBaseURL:               c.ComfyUIBaseURL,
+		ResearchOrchestratorProvider: c.ResearchOrchestratorProvider,
+		ResearchOrchestratorAPIKey:   creds.ResearchOrchestratorAPIKey,
+		ResearchOrchestratorModel:    c.ResearchOrchestratorModel,
+		ResearchOrchestratorBaseURL:  c.ResearchOrchestratorBaseURL,
+		ImageFormat:                  c.ImageFormat,
+		ImageWidth:                   c.ImageWidth,
+		ImageHeight:                  c.ImageHeight,
+		WatermarkImages:              c.WatermarkImages,
+		ImageCandidates:              c.ImageCandidates,
+		ImageMaxAttempts:             c.ImageMaxAttempts,
+		ImageScorer:                  c.ImageScorer,
+		ImageScorerModel:             c.ImageScorerModel,
+		ImageUseAvatar:               c.ImageUseAvatar,
+		AvatarOptOut:                 c.AvatarOptOut,
+		ResearchAgentPrompt:          c.ResearchAgentPrompt,
+		ContentGenerationPrompt:      c.ContentGenerationPrompt,
+		GitHubToken:                  creds.GitHubToken,
+		ModelPrices:                  c.ModelPrices,
+		LLMBudget:                    c.LLMBudget,
+		FixtureMode:                  mode,
+		FixtureDir:                   dir,
+	}
+}
+
+// replayCredentials stand in for API keys during replay, where requests never leave the
+// fixture transport but clients still refuse to start without a key.
+var replayCredentials = &Config{
+	GoogleAPIKey:               "replay",
+	OpenAIAPIKey:               "replay",
+	ResearchOrchestratorAPIKey: "replay",
+}
+
+// replaySession replays the session recorded in testdata/fixtures/<name> and checks that
+// run returns what it returned while recording. The session is skipped until it has been
+// recorded. With FIXTURE_MODE=record it is recorded instead, against the real services
+// configured in the environment (see make record-fixtures), replacing any earlier recording.
+func replaySession(t *testing.T, name string, run session) {
+	dir := filepath.Join("testdata", "fixtures", name)
+	sessionFile := filepath.Join(dir, "session.json")
+
+	if os.Getenv("FIXTURE_MODE") == FixtureRecord {
+		cfg, err := LoadConfig()
+		if err != nil {
+			t.Fatalf("loading config to record with: %v", err)
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			t.Fatalf("removing old recording: %v", err)
+		}
+		rec := recordedSession{Config: newSessionConfig(cfg)}
+		useConfig(t, rec.Config.config(cfg, FixtureRecord, dir))
+		result := run(t, newTestEnv())
+		if t.Failed() {
+			return
+		}
+		if rec.Result, err = json.Marshal(result); err != nil {
+			t.Fatalf("encoding session result: %v", err)
+		}
+		data, err := json.MarshalIndent(rec, "", "  ")
+		if err != nil {
+			t.Fatalf("encoding session: %v", err)
+		}
+		if err := os.WriteFile(sessionFile, data, 0o644); err != nil {
+			t.Fatalf("writing session: %v", err)
+		}
+		return
+	}
+
+	data, err := os.ReadFile(sessionFile)
+	if errors.Is(err, os.ErrNotExist) {
+		t.Skipf("no recorded session in %s; record one with make record-fixtures", dir)
+	}
+	if err != nil {
+		t.Fatalf("reading session: %v", err)
+	}
+	var rec recordedSession
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatalf("parsing session: %v", err)
+	}
+	useFakeGh(t, ghMustNotRun)
+	useConfig(t, rec.Config.config(replayCredentials, FixtureReplay, dir))
+	replayed, err := json.Marshal(run(t, newTestEnv()))
+	if err != nil {
+		t.Fatalf("encoding session result: %v", err)
+	}
+	var recorded bytes.Buffer
+	if err := json.Compact(&recorded, rec.Result); err != nil {
+		t.Fatalf("parsing recorded result: %v", err)
+	}
+	if !bytes.Equal(replayed, recorded.Bytes()) {
+		t.Errorf("replay produced\n%s\nbut the recorded run produced\n%s", replayed, recorded.Bytes())
+	}
+}
+
+// useConfig points the globals the activities use at cfg and a fresh bucket, and installs
+// cfg's fixtures. Everything is restored when t ends.
+func useConfig(t *testing.T, cfg *Config) {
+	t.Helper()
+	prevConfig, prevStorage, prevFixtures := appConfig, appStorage, appFixtures
+	prevLLM, prevImage, prevGemini := llmHTTPClient.Transport, imageHTTPClient.Transport, geminiHTTPClient.Transport
+	t.Cleanup(func() {
+		appConfig, appStorage, appFixtures = prevConfig, prevStorage, prevFixtures
+		llmHTTPClient.Transport, imageHTTPClient.Transport, geminiHTTPClient.Transport = prevLLM, prevImage, prevGemini
+	})
+
+	appConfig = cfg
+	appStorage = &FSStorage{Root: t.TempDir(), PublicURL: "http://localhost:8080/media"}
+	if err := installFixtures(appConfig); err != nil {
+		t.Fatalf("installFixtures: %v", err)
+	}
+}
+
+// ghMustNotRun stands in for gh during replay, where every command comes from a fixture.
+const ghMustNotRun = "#!/bin/sh\necho 'gh must not run during replay' >&2\nexit 1\n"
+
+// useFakeGh puts a gh script first on PATH for the rest of t.
+func useFakeGh(t *testing.T, script string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "gh"), []byte(script), 0o755); err != nil {
+		t.Fatalf("writing fake gh: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// newTestEnv returns a test environment with the worker's workflows and activities.
+func newTestEnv() *testsuite.TestWorkflowEnvironment {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	// Recording calls real models, which can take a while per image
+	env.SetTestTimeout(10 * time.Minute)
+
+	env.RegisterWorkflow(RunContentGenerationWorkflow)
+	env.RegisterWorkflow(AgenticScrapeGitHubProfileWorkflow)
+	env.RegisterWorkflow(PollWorkflow)
+	env.RegisterWorkflow(GeneratePollImagesWorkflow)
+	env.RegisterActivity(GenerateContentGenerationPrompt)
+	env.RegisterActivity(GenerateContent)
+	env.RegisterActivity(FetchGitHubAvatar)
+	env.RegisterActivity(RenderContributionAnimation)
+	env.RegisterActivity(RecordUsage)
+	env.RegisterActivity(ExecuteGhCommandActivity)
+	env.RegisterActivity(GenerateLLMTurnActivity)
+	env.RegisterActivity(LinkPollImage)
+	env.RegisterActivity(ComposePollCollage)
+	env.RegisterActivity(ArchivePollManifest)
+	env.RegisterActivity(TeardownPollFolder)
+	return env
+}
+
+// workflowResult fails t unless the workflow in env completed, and decodes its result.
+func workflowResult(t *testing.T, env *testsuite.TestWorkflowEnvironment, result any) {
+	t.Helper()
+	if !env.IsWorkflowCompleted() {
+		t.Fatal("workflow did not complete")
+	}
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("workflow failed: %v", err)
+	}
+	if err := env.GetWorkflowResult(result); err != nil {
+		t.Fatalf("decoding workflow result: %v", err)
+	}
+}
+
+// contentGenerationInput is the input the API starts username's workflow with under appConfig.
+func contentGenerationInput(username string) AppInput {
+	return AppInput{
+		GitHubUsername:                username,
+		ModelName:                     appConfig.GeminiModel,
+		ResearchAgentSystemPrompt:     appConfig.ResearchAgentPrompt,
+		ContentGenerationSystemPrompt: appConfig.ContentGenerationPrompt,
+		StorageBucket:                 testBucket,
+		ImageFormat:                   appConfig.ImageFormat,
+		ImageWidth:                    appConfig.ImageWidth,
+		ImageHeight:                   appConfig.ImageHeight,
+		Watermark:                     appConfig.WatermarkImages,
+		ImageCandidates:               appConfig.ImageCandidates,
+		ImageMaxAttempts:              appConfig.ImageMaxAttempts,
+		ImageScorer:                   appConfig.ImageScorer,
+		UseAvatar:                     appConfig.ImageUseAvatar,
+		ContributionAnimation:         appConfig.ContributionAnimation,
+		LLMBudget:                     appConfig.LLMBudget,
+	}
+}
+
+// comparableOutput is everything in output derived from the session; CreatedAt is
+// wall-clock time.
+func comparableOutput(output AppOutput) []any {
+	return []any{output.GitHubProfile, output.StorageKey, output.PerceptualHash, output.Variants, output.Candidates, output.Usage}
+}
+
+func TestAgenticScrapeGitHubProfileWorkflowReplay(t *testing.T) {
+	replaySession(t, "research", func(t *testing.T, env *testsuite.TestWorkflowEnvironment) any {
+		prompt := appConfig.ResearchAgentPrompt + "\n\n" + testPromptIntro + "octocat"
+		env.ExecuteWorkflow(AgenticScrapeGitHubProfileWorkflow, prompt, appConfig.LLMBudget)
+		var result ProfileScrapeResult
+		workflowResult(t, env, &result)
+
+		if result.Profile.Username != "octocat" {
+			t.Errorf("profile = %+v, want octocat's", result.Profile)
+		}
+		if result.Usage.Total().Calls == 0 {
+			t.Error("usage counts no LLM calls")
+		}
+		return result
+	})
+}
+
+func TestRunContentGenerationWorkflowReplay(t *testing.T) {
+	replaySession(t, "generation", func(t *testing.T, env *testsuite.TestWorkflowEnvironment) any {
+		env.ExecuteWorkflow(RunContentGenerationWorkflow, contentGenerationInput("octocat"))
+		var output AppOutput
+		workflowResult(t, env, &output)
+
+		if output.GitHubProfile.Username != "octocat" {
+			t.Errorf("profile = %+v, want octocat's", output.GitHubProfile)
+		}
+		if _, err := appStorage.StatInfo(context.Background(), testBucket, output.StorageKey); err != nil {
+			t.Errorf("generated image %s was not stored: %v", output.StorageKey, err)
+		}
+		return comparableOutput(output)
+	})
+}
+
+func TestPollWorkflowReplay(t *testing.T) {
+	const pollID = pollWorkflowIDPrefix + "replay"
+	usernames := []string{"octocat", "hubot"}
+
+	replaySession(t, "poll", func(t *testing.T, env *testsuite.TestWorkflowEnvironment) any {
+		env.SetStartWorkflowOptions(client.StartWorkflowOptions{ID: pollID})
+		// The mock clock only moves while no activity runs, so the vote and the end of the
+		// poll both come after every image has been generated
+		env.RegisterDelayedCallback(func() {
+			env.UpdateWorkflow("vote", "vote-1", &testsuite.TestUpdateCallback{
+				OnAccept: func() {},
+				OnReject: func(err error) { t.Errorf("vote rejected: %v", err) },
+				OnComplete: func(_ interface{}, err error) {
+					if err != nil {
+						t.Errorf("vote failed: %v", err)
+					}
+				},
+			}, VoteUpdate{UserID: "voter", Option: "octocat", Amount: 1})
+		}, time.Minute)
+		env.ExecuteWorkflow(PollWorkflow, PollConfig{
+			Question:        "Who ships more?",
+			Usernames:       usernames,
+			DurationSeconds: 3600,
+		})
+		var summary PollSummary
+		workflowResult(t, env, &summary)
+
+		if summary.Options["octocat"] != 1 {
+			t.Errorf("poll options = %v, want one vote for octocat", summary.Options)
+		}
+		keys, err := appStorage.List(context.Background(), testBucket, pollID+"/")
+		if err != nil {
+			t.Fatalf("listing poll folder: %v", err)
+		}
+		slices.Sort(keys)
+		for _, username := range usernames {
+			if !slices.Contains(keys, pollRefKey(pollID, username)) {
+				t.Errorf("poll folder %v has no image for %s", keys, username)
+			}
+		}
+		if !slices.Contains(keys, collageKey(pollID)) {
+			t.Errorf("poll folder %v has no collage", keys)
+		}
+		return []any{summary, keys}
+	})
+}